@@ -0,0 +1,215 @@
+package foundry
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// ArchiveFormat selects the container written by WriteArchive.
+type ArchiveFormat int
+
+const (
+	// ArchiveZip writes a deflate-compressed zip file.
+	ArchiveZip ArchiveFormat = iota + 1
+	// ArchiveTarGz writes a gzip-compressed tar stream.
+	ArchiveTarGz
+)
+
+func (f ArchiveFormat) String() string {
+	switch f {
+	case ArchiveZip:
+		return "zip"
+	case ArchiveTarGz:
+		return "tar.gz"
+	default:
+		return fmt.Sprintf("ArchiveFormat(%d)", int(f))
+	}
+}
+
+// archiveEpoch is stamped on every entry so identical trees produce identical
+// archives. It is the earliest time a zip file can represent.
+var archiveEpoch = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	archiveFileMode fs.FileMode = 0o644
+	archiveDirMode  fs.FileMode = 0o755
+)
+
+// ArchiveFS is an OutputFS that collects a build in memory and then writes it
+// out as a single deterministic archive. It is safe for concurrent writes, so
+// it can back an Output used from ForEachParallel.
+type ArchiveFS struct {
+	*MemFS
+	format ArchiveFormat
+}
+
+// NewArchiveFS returns an empty ArchiveFS producing archives in format.
+func NewArchiveFS(format ArchiveFormat) *ArchiveFS {
+	return &ArchiveFS{MemFS: NewMemFS(), format: format}
+}
+
+// WriteTo writes the archive to w.
+func (a *ArchiveFS) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := WriteArchive(cw, a.MemFS.MapFS(), a.format)
+	return cw.n, err
+}
+
+// Save writes the archive to path with WriteIfChanged, so rebuilding an
+// unchanged site leaves the existing bundle untouched.
+func (a *ArchiveFS) Save(path string) error {
+	var buf bytes.Buffer
+	if _, err := a.WriteTo(&buf); err != nil {
+		return err
+	}
+	return WriteIfChanged(path, buf.Bytes())
+}
+
+// WriteArchive writes every file and directory in fsys to w as a zip or
+// tar.gz archive. Entries are sorted by path and carry fixed timestamps,
+// ownership and modes, so the same tree always yields byte-identical output.
+// Symbolic links to files are stored as regular files; links to directories
+// are skipped.
+func WriteArchive(w io.Writer, fsys fs.FS, format ArchiveFormat) error {
+	if w == nil {
+		return errors.New("foundry: archive writer is nil")
+	}
+	if fsys == nil {
+		return errors.New("foundry: archive filesystem is nil")
+	}
+
+	var aw archiveWriter
+	switch format {
+	case ArchiveZip:
+		aw = &zipArchive{zw: zip.NewWriter(w)}
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		aw = &tarArchive{gz: gz, tw: tar.NewWriter(gz)}
+	default:
+		return fmt.Errorf("foundry: unknown archive format %v", format)
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		isDir := d.IsDir()
+		if d.Type()&fs.ModeSymlink != 0 {
+			info, err := fs.Stat(fsys, name)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+		}
+		if isDir {
+			return aw.dir(name)
+		}
+		if !d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		return aw.file(name, data)
+	})
+	if err != nil {
+		_ = aw.close()
+		return fmt.Errorf("foundry: write %s archive: %w", format, err)
+	}
+	if err := aw.close(); err != nil {
+		return fmt.Errorf("foundry: write %s archive: %w", format, err)
+	}
+	return nil
+}
+
+type archiveWriter interface {
+	dir(name string) error
+	file(name string, data []byte) error
+	close() error
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (z *zipArchive) dir(name string) error {
+	hdr := &zip.FileHeader{Name: name + "/", Method: zip.Store, Modified: archiveEpoch}
+	hdr.SetMode(fs.ModeDir | archiveDirMode)
+	_, err := z.zw.CreateHeader(hdr)
+	return err
+}
+
+func (z *zipArchive) file(name string, data []byte) error {
+	hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: archiveEpoch}
+	hdr.SetMode(archiveFileMode)
+	fw, err := z.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+func (z *zipArchive) close() error {
+	return z.zw.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarArchive) dir(name string) error {
+	return t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     int64(archiveDirMode),
+		ModTime:  archiveEpoch,
+	})
+}
+
+func (t *tarArchive) file(name string, data []byte) error {
+	if err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(archiveFileMode),
+		Size:     int64(len(data)),
+		ModTime:  archiveEpoch,
+	}); err != nil {
+		return err
+	}
+	_, err := t.tw.Write(data)
+	return err
+}
+
+func (t *tarArchive) close() error {
+	if err := t.tw.Close(); err != nil {
+		_ = t.gz.Close()
+		return err
+	}
+	return t.gz.Close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
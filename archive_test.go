@@ -0,0 +1,129 @@
+package foundry
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func buildArchive(t *testing.T, format ArchiveFormat) []byte {
+	t.Helper()
+	archive := NewArchiveFS(format)
+	out, err := NewOutputFS(archive, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	pages := []string{"index.html", "en/about.html", "es/about.html", "assets/site.css"}
+	if err := ForEachParallel(pages, 4, func(rel string) {
+		if err := out.WriteIfChanged(rel, []byte("content of "+rel)); err != nil {
+			panic(err)
+		}
+	}); err != nil {
+		t.Fatalf("ForEachParallel: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := archive.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestArchiveZipDeterministic(t *testing.T) {
+	first := buildArchive(t, ArchiveZip)
+	second := buildArchive(t, ArchiveZip)
+	if !bytes.Equal(first, second) {
+		t.Fatalf("expected identical zip archives")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(first), int64(len(first)))
+	if err != nil {
+		t.Fatalf("open zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if !f.Modified.Equal(archiveEpoch) {
+			t.Fatalf("%s: unexpected timestamp %s", f.Name, f.Modified)
+		}
+	}
+	want := []string{"assets/", "assets/site.css", "en/", "en/about.html", "es/", "es/about.html", "index.html"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("entries got %v want %v", names, want)
+	}
+	rc, err := zr.Open("en/about.html")
+	if err != nil {
+		t.Fatalf("open entry: %v", err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read entry: %v", err)
+	}
+	if string(data) != "content of en/about.html" {
+		t.Fatalf("unexpected entry content %q", data)
+	}
+}
+
+func TestArchiveTarGzDeterministic(t *testing.T) {
+	first := buildArchive(t, ArchiveTarGz)
+	second := buildArchive(t, ArchiveTarGz)
+	if !bytes.Equal(first, second) {
+		t.Fatalf("expected identical tar.gz archives")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(first))
+	if err != nil {
+		t.Fatalf("open gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		names = append(names, hdr.Name+":"+strconv.FormatInt(hdr.Mode, 8))
+	}
+	want := []string{"assets/:755", "assets/site.css:644", "en/:755", "en/about.html:644", "es/:755", "es/about.html:644", "index.html:644"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("entries got %v want %v", names, want)
+	}
+}
+
+func TestArchiveFSSaveUnchanged(t *testing.T) {
+	archive := NewArchiveFS(ArchiveZip)
+	if err := WriteIfChangedFS(archive, "index.html", []byte("home")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "site.zip")
+	if err := archive.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := archive.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	action, err := writeIfChanged(osFS{}, path, buf.Bytes(), FileAttrs{})
+	if err != nil {
+		t.Fatalf("compare: %v", err)
+	}
+	if action != ActionUnchanged {
+		t.Fatalf("expected saved archive to match a fresh rendering")
+	}
+}
+
+func TestWriteArchiveUnknownFormat(t *testing.T) {
+	if err := WriteArchive(io.Discard, NewMemFS(), ArchiveFormat(0)); err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+}
@@ -0,0 +1,303 @@
+package foundry
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const defaultHashLength = 8
+
+// AssetOptions configures NewAssets.
+type AssetOptions struct {
+	// URLPrefix is prepended to fingerprinted paths by URL and the "asset"
+	// template function. It defaults to "/".
+	URLPrefix string
+	// HashLength is the number of hex digits of the content hash placed in
+	// file names. It defaults to 8.
+	HashLength int
+}
+
+// AssetEntry describes one fingerprinted asset.
+type AssetEntry struct {
+	// Path is the fingerprinted path relative to the output root.
+	Path string `json:"path"`
+	// URL is Path joined to the configured URL prefix.
+	URL string `json:"url"`
+	// Integrity is the Subresource Integrity digest of the content, such as
+	// "sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC".
+	Integrity string `json:"integrity"`
+}
+
+// Assets writes content-hashed copies of assets such as "css/app.css" as
+// "css/app.3f9a1c2e.css" into an Output and remembers the mapping so
+// templates can link to the current version. It is safe for concurrent use.
+type Assets struct {
+	out        *Output
+	prefix     string
+	hashLength int
+
+	mu      sync.RWMutex
+	entries map[string]AssetEntry
+}
+
+// NewAssets returns an empty asset mapping that writes into out.
+func NewAssets(out *Output, opts AssetOptions) (*Assets, error) {
+	if out == nil {
+		return nil, errors.New("foundry: assets output is nil")
+	}
+	if opts.URLPrefix == "" {
+		opts.URLPrefix = "/"
+	}
+	if opts.HashLength == 0 {
+		opts.HashLength = defaultHashLength
+	}
+	if opts.HashLength < 4 || opts.HashLength > 64 {
+		return nil, fmt.Errorf("foundry: asset hash length must be between 4 and 64 (got %d)", opts.HashLength)
+	}
+	return &Assets{
+		out:        out,
+		prefix:     opts.URLPrefix,
+		hashLength: opts.HashLength,
+		entries:    make(map[string]AssetEntry),
+	}, nil
+}
+
+// WriteFingerprinted writes content under a fingerprinted version of the
+// logical path and records the mapping. It returns the fingerprinted path.
+func (a *Assets) WriteFingerprinted(logical string, content []byte) (string, error) {
+	key, err := a.out.resolve(logical)
+	if err != nil {
+		return "", err
+	}
+	// Hash what is served, so fingerprints and integrity digests match.
+	if content, err = a.out.transform(key, content); err != nil {
+		return "", err
+	}
+	hashed := fingerprintPath(key, hashBytes(content)[:a.hashLength])
+	if a.current(key, hashed) {
+		return hashed, nil
+	}
+	hashed, err = a.out.claim(hashed)
+	if err != nil {
+		return "", err
+	}
+	if _, err := a.out.writeClaimed(hashed, content, a.out.attrs); err != nil {
+		return "", err
+	}
+	sum := sha512.Sum384(content)
+	a.set(key, hashed, sriDigest(sum[:]))
+	return hashed, nil
+}
+
+// CopyFingerprinted copies srcPath to a fingerprinted version of the logical
+// path and records the mapping. It returns the fingerprinted path.
+func (a *Assets) CopyFingerprinted(srcPath string, logical string) (string, error) {
+	key, err := a.out.resolve(logical)
+	if err != nil {
+		return "", err
+	}
+	if a.out.transformFor(key) != nil {
+		content, err := os.ReadFile(srcPath)
+		if err != nil {
+			return "", fmt.Errorf("foundry: read source file: %w", err)
+		}
+		return a.WriteFingerprinted(key, content)
+	}
+	hash, integrity, err := digestFile(srcPath)
+	if err != nil {
+		return "", err
+	}
+	hashed := fingerprintPath(key, hash[:a.hashLength])
+	if a.current(key, hashed) {
+		return hashed, nil
+	}
+	if _, err := a.out.copyFileIfChanged(srcPath, hashed); err != nil {
+		return "", err
+	}
+	a.set(key, hashed, integrity)
+	return hashed, nil
+}
+
+// Lookup returns the entry recorded for logical.
+func (a *Assets) Lookup(logical string) (AssetEntry, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	entry, ok := a.entries[path.Clean(filepath.ToSlash(logical))]
+	return entry, ok
+}
+
+// URL returns the public URL of the fingerprinted version of logical. It fails
+// if the asset has not been written in this build.
+func (a *Assets) URL(logical string) (string, error) {
+	entry, ok := a.Lookup(logical)
+	if !ok {
+		return "", fmt.Errorf("foundry: asset %q was not produced in this build", logical)
+	}
+	return entry.URL, nil
+}
+
+// Integrity returns the Subresource Integrity digest of logical. It fails if
+// the asset has not been written in this build.
+func (a *Assets) Integrity(logical string) (string, error) {
+	entry, ok := a.Lookup(logical)
+	if !ok {
+		return "", fmt.Errorf("foundry: asset %q was not produced in this build", logical)
+	}
+	return entry.Integrity, nil
+}
+
+// integrityAttr renders the integrity attribute for logical.
+func (a *Assets) integrityAttr(logical string) (template.HTMLAttr, error) {
+	digest, err := a.Integrity(logical)
+	if err != nil {
+		return "", err
+	}
+	return template.HTMLAttr(`integrity="` + digest + `"`), nil
+}
+
+// Entries returns a copy of every recorded mapping keyed by logical path.
+func (a *Assets) Entries() map[string]AssetEntry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return maps.Clone(a.entries)
+}
+
+// WriteJSON writes the mapping as a JSON object keyed by logical path, for
+// tooling outside the build.
+func (a *Assets) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(a.Entries()); err != nil {
+		return fmt.Errorf("foundry: encode asset manifest: %w", err)
+	}
+	return nil
+}
+
+// TemplateFuncs exposes the "asset" function, which maps a logical asset path
+// to its fingerprinted URL, and the "integrity" function, which renders its
+// integrity="sha384-..." attribute:
+//
+//	<script src="{{asset "js/app.js"}}" {{integrity "js/app.js"}}></script>
+//
+// Both fail template execution for assets not produced in this build. Assets
+// served from another origin also need crossorigin="anonymous".
+func (a *Assets) TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"asset":     a.URL,
+		"integrity": a.integrityAttr,
+	}
+}
+
+// RemoveStale deletes fingerprinted versions of every recorded asset other
+// than the current one, along with their ".gz" companions and manifest
+// entries, and returns their paths. Output.Prune removes them too;
+// RemoveStale is for builds that do not prune.
+func (a *Assets) RemoveStale() ([]string, error) {
+	var removed []string
+	fsys := a.out.FS()
+	for logical, entry := range a.Entries() {
+		dir := path.Dir(logical)
+		pattern := a.versionPattern(logical)
+		entries, err := fs.ReadDir(fsys, dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return removed, fmt.Errorf("foundry: remove stale assets: %w", err)
+		}
+		for _, e := range entries {
+			name := path.Join(dir, e.Name())
+			if e.IsDir() || name == entry.Path || name == entry.Path+".gz" || !pattern.MatchString(e.Name()) {
+				continue
+			}
+			if !a.out.dryRun {
+				if err := fsys.Remove(name); err != nil {
+					return removed, fmt.Errorf("foundry: remove stale assets: %w", err)
+				}
+				if a.out.manifest != nil {
+					a.out.manifest.Delete(name)
+				}
+			}
+			a.out.recordRemoved(Change{Path: name, Action: ActionRemove})
+			removed = append(removed, name)
+		}
+	}
+	slices.Sort(removed)
+	return removed, nil
+}
+
+// current reports whether hashed was already written for logical in this
+// build, so that repeated registrations do not count as duplicate writes.
+func (a *Assets) current(logical string, hashed string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.entries[logical].Path == hashed
+}
+
+func (a *Assets) set(logical string, hashed string, integrity string) {
+	a.mu.Lock()
+	a.entries[logical] = AssetEntry{Path: hashed, URL: joinURL(a.prefix, hashed), Integrity: integrity}
+	a.mu.Unlock()
+}
+
+// digestFile reads srcPath once and returns its hex SHA-256 for the
+// fingerprint and its Subresource Integrity digest.
+func digestFile(srcPath string) (string, string, error) {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return "", "", fmt.Errorf("foundry: open source file: %w", err)
+	}
+	defer f.Close()
+	h256, h384 := sha256.New(), sha512.New384()
+	if _, err := io.Copy(io.MultiWriter(h256, h384), f); err != nil {
+		return "", "", fmt.Errorf("foundry: hash source file: %w", err)
+	}
+	return hex.EncodeToString(h256.Sum(nil)), sriDigest(h384.Sum(nil)), nil
+}
+
+func sriDigest(sum384 []byte) string {
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum384)
+}
+
+// versionPattern matches the base names of any fingerprinted version of
+// logical and of its ".gz" companion.
+func (a *Assets) versionPattern(logical string) *regexp.Regexp {
+	stem, ext := splitExt(path.Base(logical))
+	return regexp.MustCompile(fmt.Sprintf(`^%s\.[0-9a-f]{%d}%s(\.gz)?$`, regexp.QuoteMeta(stem), a.hashLength, regexp.QuoteMeta(ext)))
+}
+
+// fingerprintPath inserts hash before the extension of key.
+func fingerprintPath(key string, hash string) string {
+	stem, ext := splitExt(path.Base(key))
+	return path.Join(path.Dir(key), stem+"."+hash+ext)
+}
+
+// splitExt splits base into stem and extension, treating dotfiles such as
+// ".htaccess" as having no extension.
+func splitExt(base string) (string, string) {
+	ext := path.Ext(base)
+	if ext == base {
+		return base, ""
+	}
+	return strings.TrimSuffix(base, ext), ext
+}
+
+func joinURL(prefix string, rel string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + rel
+}
@@ -0,0 +1,191 @@
+package foundry
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssetsWriteFingerprinted(t *testing.T) {
+	mem := NewMemFS()
+	out, err := NewOutputFS(mem, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	assets, err := NewAssets(out, AssetOptions{URLPrefix: "/static/"})
+	if err != nil {
+		t.Fatalf("NewAssets: %v", err)
+	}
+
+	css := []byte("body{color:red}")
+	hashed, err := assets.WriteFingerprinted("css/app.css", css)
+	if err != nil {
+		t.Fatalf("WriteFingerprinted: %v", err)
+	}
+	want := "css/app." + hashBytes(css)[:8] + ".css"
+	if hashed != want {
+		t.Fatalf("hashed path got %q want %q", hashed, want)
+	}
+	if _, err := mem.Stat(hashed); err != nil {
+		t.Fatalf("expected fingerprinted file: %v", err)
+	}
+
+	url, err := assets.URL("css/app.css")
+	if err != nil {
+		t.Fatalf("URL: %v", err)
+	}
+	if url != "/static/"+want {
+		t.Fatalf("url got %q", url)
+	}
+
+	tmpl := template.Must(template.New("page").Funcs(assets.TemplateFuncs()).Parse(`<link href="{{ asset "css/app.css" }}">`))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if got := buf.String(); got != `<link href="/static/`+want+`">` {
+		t.Fatalf("template output %q", got)
+	}
+
+	missing := template.Must(template.New("page").Funcs(assets.TemplateFuncs()).Parse(`{{ asset "js/none.js" }}`))
+	if err := missing.Execute(&buf, nil); err == nil || !strings.Contains(err.Error(), "js/none.js") {
+		t.Fatalf("expected missing asset error, got %v", err)
+	}
+
+	var js bytes.Buffer
+	if err := assets.WriteJSON(&js); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var decoded map[string]AssetEntry
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoded["css/app.css"].Path != want {
+		t.Fatalf("unexpected manifest %v", decoded)
+	}
+}
+
+func TestAssetsCopyFingerprintedAndRemoveStale(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(t.TempDir(), "logo.svg")
+	if err := os.WriteFile(src, []byte("v1"), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+	manifest := NewManifest()
+
+	build := func() (*Assets, string) {
+		out, err := NewOutput(root, OutputOptions{Manifest: manifest, Gzip: &GzipOptions{MinSize: 1}})
+		if err != nil {
+			t.Fatalf("NewOutput: %v", err)
+		}
+		assets, err := NewAssets(out, AssetOptions{})
+		if err != nil {
+			t.Fatalf("NewAssets: %v", err)
+		}
+		hashed, err := assets.CopyFingerprinted(src, "img/logo.svg")
+		if err != nil {
+			t.Fatalf("CopyFingerprinted: %v", err)
+		}
+		return assets, hashed
+	}
+
+	_, first := build()
+	if err := os.WriteFile(src, []byte("v2"), 0o644); err != nil {
+		t.Fatalf("rewrite src: %v", err)
+	}
+	assets, second := build()
+	if first == second {
+		t.Fatalf("expected new fingerprint after content change")
+	}
+	if err := WriteIfChanged(filepath.Join(root, "img", "logo.txt"), []byte("unrelated")); err != nil {
+		t.Fatalf("write unrelated: %v", err)
+	}
+	if _, ok := manifest.Get(first); !ok {
+		t.Fatalf("expected a manifest entry for %s", first)
+	}
+
+	removed, err := assets.RemoveStale()
+	if err != nil {
+		t.Fatalf("RemoveStale: %v", err)
+	}
+	if want := []string{first, first + ".gz"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removed got %v want %v", removed, want)
+	}
+	for _, rel := range []string{second, second + ".gz", "img/logo.txt"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(rel))); err != nil {
+			t.Fatalf("expected %s to remain: %v", rel, err)
+		}
+	}
+	for _, rel := range removed {
+		if _, ok := manifest.Get(rel); ok {
+			t.Fatalf("manifest still lists %s", rel)
+		}
+	}
+	if _, ok := manifest.Get(second); !ok {
+		t.Fatalf("manifest lost the current version %s", second)
+	}
+}
+
+func TestFingerprintPath(t *testing.T) {
+	cases := map[string]string{
+		"css/app.css":    "css/app.abcd.css",
+		"js/app.min.js":  "js/app.min.abcd.js",
+		"LICENSE":        "LICENSE.abcd",
+		"conf/.htaccess": "conf/.htaccess.abcd",
+	}
+	for in, want := range cases {
+		if got := fingerprintPath(in, "abcd"); got != want {
+			t.Fatalf("fingerprintPath(%q) got %q want %q", in, got, want)
+		}
+	}
+}
+
+func TestAssetsIntegrity(t *testing.T) {
+	out, err := NewOutputFS(NewMemFS(), OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	assets, err := NewAssets(out, AssetOptions{})
+	if err != nil {
+		t.Fatalf("NewAssets: %v", err)
+	}
+	script := []byte("alert('Hello, world.');")
+	if _, err := assets.WriteFingerprinted("js/app.js", script); err != nil {
+		t.Fatalf("WriteFingerprinted: %v", err)
+	}
+	src := filepath.Join(t.TempDir(), "app.js")
+	if err := os.WriteFile(src, script, 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+	if _, err := assets.CopyFingerprinted(src, "js/copy.js"); err != nil {
+		t.Fatalf("CopyFingerprinted: %v", err)
+	}
+
+	// Digest from the Subresource Integrity examples on MDN.
+	want := "sha384-H8BRh8j48O9oYatfu5AZzq6A9RINhZO5H16dQZngK7T62em8MUt1FLm52t+eX6xO"
+	for _, logical := range []string{"js/app.js", "js/copy.js"} {
+		if got, err := assets.Integrity(logical); err != nil || got != want {
+			t.Fatalf("%s: integrity got %q (%v) want %q", logical, got, err, want)
+		}
+	}
+
+	tmpl := template.Must(template.New("page").Funcs(assets.TemplateFuncs()).Parse(`<script src="{{ asset "js/app.js" }}" {{ integrity "js/app.js" }}></script>`))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	entry, _ := assets.Lookup("js/app.js")
+	if got := buf.String(); got != `<script src="`+entry.URL+`" integrity="`+want+`"></script>` {
+		t.Fatalf("template output %q", got)
+	}
+
+	missing := template.Must(template.New("page").Funcs(assets.TemplateFuncs()).Parse(`{{ integrity "js/none.js" }}`))
+	if err := missing.Execute(&buf, nil); err == nil || !strings.Contains(err.Error(), "js/none.js") {
+		t.Fatalf("expected missing asset error, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package foundry
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultFileMode is requested for new files when FileAttrs.Mode is zero. The
+// process umask still applies, exactly as with os.WriteFile.
+const defaultFileMode fs.FileMode = 0o644
+
+// defaultPerm returns defaultFileMode filtered by the process umask, the mode
+// a new file actually gets. It is probed once by creating a file, since
+// reading the umask portably means briefly changing it for every goroutine.
+var defaultPerm = sync.OnceValue(func() fs.FileMode {
+	fallback := defaultFileMode &^ 0o022
+	dir, err := os.MkdirTemp("", "foundry-umask-")
+	if err != nil {
+		return fallback
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.OpenFile(filepath.Join(dir, "probe"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, defaultFileMode)
+	if err != nil {
+		return fallback
+	}
+	info, err := f.Stat()
+	_ = f.Close()
+	if err != nil {
+		return fallback
+	}
+	return info.Mode().Perm() & defaultFileMode
+})
+
+// FileAttrs controls the permission bits and modification time of files
+// written through an OutputFS.
+type FileAttrs struct {
+	// Mode holds the permission bits to apply. Zero requests 0644 filtered
+	// by the process umask, and widens unchanged files that lack any of
+	// those bits; any other value is applied exactly, and is also restored
+	// on files whose content is unchanged.
+	Mode fs.FileMode
+	// ModTime, when non-zero, is stamped on written files and on unchanged
+	// files whose modification time differs, which keeps reproducible
+	// builds byte- and timestamp-identical.
+	ModTime time.Time
+}
+
+// matches reports whether info already satisfies the explicit parts of a.
+// With no explicit Mode, a regular file must carry at least the default
+// permission bits.
+func (a FileAttrs) matches(info fs.FileInfo) bool {
+	if a.Mode != 0 && info.Mode().Perm() != a.Mode.Perm() {
+		return false
+	}
+	if a.Mode == 0 && info.Mode().IsRegular() && info.Mode().Perm()&defaultPerm() != defaultPerm() {
+		return false
+	}
+	if !a.ModTime.IsZero() && !info.ModTime().Equal(a.ModTime) {
+		return false
+	}
+	return true
+}
+
+// SourceDateEpoch returns the time held in the SOURCE_DATE_EPOCH environment
+// variable, the reproducible-builds convention for a fixed build timestamp.
+// ok is false when the variable is unset.
+func SourceDateEpoch() (t time.Time, ok bool, err error) {
+	raw, set := os.LookupEnv("SOURCE_DATE_EPOCH")
+	if !set || raw == "" {
+		return time.Time{}, false, nil
+	}
+	secs, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("foundry: invalid SOURCE_DATE_EPOCH %q: %w", raw, err)
+	}
+	return time.Unix(secs, 0).UTC(), true, nil
+}
+
+// fixAttrs applies the explicit parts of attrs to an existing file whose
+// content did not need rewriting. Without an explicit Mode, a file narrower
+// than the default, say 0600 from an earlier build or another tool, is
+// widened to the default so it stays readable by the web server.
+func fixAttrs(fsys OutputFS, name string, attrs FileAttrs) error {
+	info, err := fsys.Stat(name)
+	if err != nil {
+		return fmt.Errorf("foundry: stat existing file: %w", err)
+	}
+	if attrs.matches(info) {
+		return nil
+	}
+	if attrs.Mode == 0 && info.Mode().Perm()&defaultPerm() != defaultPerm() {
+		attrs.Mode = info.Mode().Perm() | defaultPerm()
+	}
+	return fsys.SetAttrs(name, attrs)
+}
+
+// rewriteWithAttrs replaces the file at path with a new copy carrying attrs,
+// so that other hard links to the old file keep their attributes. Attributes
+// attrs leaves unset are carried over from the old file.
+func rewriteWithAttrs(ops fileOps, path string, attrs FileAttrs) error {
+	f, err := ops.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("foundry: open existing file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("foundry: stat existing file: %w", err)
+	}
+	if attrs.Mode == 0 {
+		attrs.Mode = info.Mode().Perm()
+	}
+	if attrs.ModTime.IsZero() {
+		attrs.ModTime = info.ModTime()
+	}
+	// The copy is streamed, and the old file closed before the rename, since
+	// Windows cannot replace a file that is still open.
+	tmp, err := writeTemp(ops, filepath.Dir(path), f, attrs)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		_ = ops.Remove(tmp)
+		err = fmt.Errorf("foundry: close existing file: %w", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	return renameTemp(ops, tmp, path)
+}
+
+// setDiskAttrs applies the explicit parts of attrs to the file at path.
+func setDiskAttrs(ops fileOps, path string, attrs FileAttrs) error {
+	if attrs.Mode != 0 {
+		if err := ops.Chmod(path, attrs.Mode.Perm()); err != nil {
+			return fmt.Errorf("foundry: set file mode: %w", err)
+		}
+	}
+	if !attrs.ModTime.IsZero() {
+		if err := ops.Chtimes(path, attrs.ModTime, attrs.ModTime); err != nil {
+			return fmt.Errorf("foundry: set file time: %w", err)
+		}
+	}
+	return nil
+}
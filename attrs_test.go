@@ -0,0 +1,176 @@
+package foundry
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteIfChangedDefaultModeHonorsUmask(t *testing.T) {
+	dir := t.TempDir()
+	// os.WriteFile applies the umask the same way a plain write should.
+	ref := filepath.Join(dir, "ref.html")
+	if err := os.WriteFile(ref, []byte("ref"), 0o644); err != nil {
+		t.Fatalf("write ref: %v", err)
+	}
+	path := filepath.Join(dir, "index.html")
+	if err := WriteIfChanged(path, []byte("home")); err != nil {
+		t.Fatalf("WriteIfChanged: %v", err)
+	}
+	want, err := os.Stat(ref)
+	if err != nil {
+		t.Fatalf("stat ref: %v", err)
+	}
+	got, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if got.Mode().Perm() != want.Mode().Perm() {
+		t.Fatalf("mode got %o want %o", got.Mode().Perm(), want.Mode().Perm())
+	}
+}
+
+func TestWriteIfChangedWidensNarrowUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	ref := filepath.Join(dir, "ref.html")
+	if err := os.WriteFile(ref, []byte("ref"), 0o644); err != nil {
+		t.Fatalf("write ref: %v", err)
+	}
+	want, err := os.Stat(ref)
+	if err != nil {
+		t.Fatalf("stat ref: %v", err)
+	}
+	// An earlier tool left the page readable only by its owner.
+	path := filepath.Join(dir, "index.html")
+	if err := os.WriteFile(path, []byte("home"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := WriteIfChanged(path, []byte("home")); err != nil {
+		t.Fatalf("WriteIfChanged: %v", err)
+	}
+	got, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if got.Mode().Perm() != want.Mode().Perm() {
+		t.Fatalf("mode got %o want %o", got.Mode().Perm(), want.Mode().Perm())
+	}
+}
+
+func TestOutputFileModeAndModTime(t *testing.T) {
+	root := t.TempDir()
+	stamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	out, err := NewOutput(root, OutputOptions{FileMode: 0o640, ModTime: stamp})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+	if err := out.WriteIfChanged("a.html", []byte("a")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	path := filepath.Join(root, "a.html")
+	assertAttrs(t, path, 0o640, stamp)
+
+	// Unchanged content still gets its attributes restored.
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := os.Chtimes(path, time.Now(), time.Now()); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	if err := out.WriteIfChanged("a.html", []byte("a")); err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	assertAttrs(t, path, 0o640, stamp)
+	if got := out.ChangeReport().Count(ActionUnchanged); got != 1 {
+		t.Fatalf("expected unchanged rewrite, got %d unchanged", got)
+	}
+}
+
+func TestOutputPreserveSourceAttrs(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "run.sh")
+	if err := os.WriteFile(src, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+	srcTime := time.Date(2020, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := os.Chtimes(src, srcTime, srcTime); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	root := t.TempDir()
+	out, err := NewOutput(root, OutputOptions{
+		ModTime:         time.Unix(0, 0),
+		PreserveMode:    true,
+		PreserveModTime: true,
+		Manifest:        NewManifest(),
+	})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+	if err := out.CopyFileIfChanged(src, "bin/run.sh"); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	assertAttrs(t, filepath.Join(root, "bin", "run.sh"), 0o755, srcTime)
+
+	// The manifest fast path notices a mode change on the source.
+	if err := os.Chmod(src, 0o700); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := out.CopyFileIfChanged(src, "bin/run.sh"); err != nil {
+		t.Fatalf("recopy: %v", err)
+	}
+	assertAttrs(t, filepath.Join(root, "bin", "run.sh"), 0o700, srcTime)
+}
+
+func TestMemFSAttrs(t *testing.T) {
+	stamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	mem := NewMemFS()
+	out, err := NewOutputFS(mem, OutputOptions{FileMode: 0o600, ModTime: stamp})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	if err := out.WriteIfChanged("a.txt", []byte("a")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	info, err := mem.Stat("a.txt")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode() != 0o600 || !info.ModTime().Equal(stamp) {
+		t.Fatalf("unexpected attrs %v %s", info.Mode(), info.ModTime())
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	if _, ok, err := SourceDateEpoch(); ok || err != nil {
+		t.Fatalf("expected unset, got ok=%v err=%v", ok, err)
+	}
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	got, ok, err := SourceDateEpoch()
+	if err != nil || !ok || !got.Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("got %s ok=%v err=%v", got, ok, err)
+	}
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	if _, _, err := SourceDateEpoch(); err == nil {
+		t.Fatalf("expected error for invalid value")
+	}
+}
+
+func assertAttrs(t *testing.T, path string, mode fs.FileMode, modTime time.Time) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat %s: %v", path, err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Fatalf("%s: mode got %o want %o", path, info.Mode().Perm(), mode)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Fatalf("%s: mtime got %s want %s", path, info.ModTime(), modTime)
+	}
+}
@@ -0,0 +1,239 @@
+package foundry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// ChangeAction describes what a write did, or would do in a dry run, to one
+// output path.
+type ChangeAction int
+
+const (
+	// ActionUnchanged means the output already held the expected content.
+	ActionUnchanged ChangeAction = iota + 1
+	// ActionCreate means the output did not exist before.
+	ActionCreate
+	// ActionUpdate means the output existed with different content.
+	ActionUpdate
+	// ActionRemove means Prune deleted the output.
+	ActionRemove
+)
+
+// writes reports whether the action put new content into the output.
+func (a ChangeAction) writes() bool {
+	return a == ActionCreate || a == ActionUpdate
+}
+
+// writtenBytes returns size when action wrote content and zero otherwise.
+func writtenBytes(action ChangeAction, size int64) int64 {
+	if !action.writes() {
+		return 0
+	}
+	return size
+}
+
+var changeActionNames = map[ChangeAction]string{
+	ActionUnchanged: "unchanged",
+	ActionCreate:    "create",
+	ActionUpdate:    "update",
+	ActionRemove:    "remove",
+}
+
+func (a ChangeAction) String() string {
+	if name, ok := changeActionNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("ChangeAction(%d)", int(a))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (a ChangeAction) MarshalText() ([]byte, error) {
+	if name, ok := changeActionNames[a]; ok {
+		return []byte(name), nil
+	}
+	return nil, fmt.Errorf("foundry: unknown change action %d", int(a))
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (a *ChangeAction) UnmarshalText(text []byte) error {
+	for action, name := range changeActionNames {
+		if name == string(text) {
+			*a = action
+			return nil
+		}
+	}
+	return fmt.Errorf("foundry: unknown change action %q", text)
+}
+
+// maxDiffBytes bounds how much content a dry run keeps per side of a change.
+const maxDiffBytes = 1 << 20
+
+// Change records the outcome for one output path.
+type Change struct {
+	Path   string       `json:"path"`
+	Action ChangeAction `json:"action"`
+	// Bytes is the size of the content written, or that a dry run would
+	// write. It is zero for unchanged and removed files.
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// before and after hold the text on either side of a dry-run change;
+	// diffable is false when either side was binary or too large to keep.
+	before, after []byte
+	diffable      bool
+}
+
+// ChangeStats counts the outcomes of a set of changes.
+type ChangeStats struct {
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Removed   int `json:"removed"`
+	// Changed is Created+Updated+Removed, so consumers of the JSON form can
+	// skip work when it is zero.
+	Changed int `json:"changed"`
+	// BytesWritten totals Change.Bytes.
+	BytesWritten int64 `json:"bytesWritten"`
+}
+
+func (s *ChangeStats) add(c Change) {
+	switch c.Action {
+	case ActionCreate:
+		s.Created++
+	case ActionUpdate:
+		s.Updated++
+	case ActionUnchanged:
+		s.Unchanged++
+	case ActionRemove:
+		s.Removed++
+	}
+	if c.Action != ActionUnchanged {
+		s.Changed++
+	}
+	s.BytesWritten += c.Bytes
+}
+
+// ChangeReport lists the outcome of every path an Output wrote, confirmed
+// unchanged or pruned, sorted by path, together with totals for the whole
+// build and for each top-level directory.
+type ChangeReport struct {
+	Changes []Change `json:"changes"`
+	// Totals counts every change.
+	Totals ChangeStats `json:"totals"`
+	// Dirs counts changes by the first element of their path. Files at the
+	// output root are counted under ".".
+	Dirs map[string]ChangeStats `json:"dirs"`
+}
+
+// Count returns the number of changes with the given action.
+func (r *ChangeReport) Count(action ChangeAction) int {
+	n := 0
+	for _, c := range r.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Changed returns the changes that created, updated or removed a file.
+func (r *ChangeReport) Changed() []Change {
+	var out []Change
+	for _, c := range r.Changes {
+		if c.Action != ActionUnchanged {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Summary returns a one-line description such as
+// "3 files changed (2 created, 1 updated, 0 removed), 40 unchanged".
+func (r *ChangeReport) Summary() string {
+	created := r.Count(ActionCreate)
+	updated := r.Count(ActionUpdate)
+	removed := r.Count(ActionRemove)
+	noun := "files"
+	if created+updated+removed == 1 {
+		noun = "file"
+	}
+	return fmt.Sprintf("%d %s changed (%d created, %d updated, %d removed), %d unchanged",
+		created+updated+removed, noun, created, updated, removed, r.Count(ActionUnchanged))
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *ChangeReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("foundry: encode change report: %w", err)
+	}
+	return nil
+}
+
+// WriteDiff writes a unified diff for every created, updated or removed file.
+// Contents are only retained by dry runs; other changes, and binary or very
+// large files, are listed without a body.
+func (r *ChangeReport) WriteDiff(w io.Writer) error {
+	var b strings.Builder
+	for _, c := range r.Changed() {
+		oldName, newName := "a/"+c.Path, "b/"+c.Path
+		switch c.Action {
+		case ActionCreate:
+			oldName = "/dev/null"
+		case ActionRemove:
+			newName = "/dev/null"
+		}
+		if !c.diffable {
+			fmt.Fprintf(&b, "Files %s and %s differ\n", oldName, newName)
+			continue
+		}
+		b.WriteString(unifiedDiff(oldName, newName, string(c.before), string(c.after)))
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("foundry: write diff: %w", err)
+	}
+	return nil
+}
+
+func newChangeReport(changes []Change) *ChangeReport {
+	slices.SortFunc(changes, func(a, b Change) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return int(a.Action) - int(b.Action)
+	})
+	r := &ChangeReport{Changes: changes, Dirs: make(map[string]ChangeStats)}
+	for _, c := range changes {
+		r.Totals.add(c)
+		dir, _, ok := strings.Cut(c.Path, "/")
+		if !ok {
+			dir = "."
+		}
+		stats := r.Dirs[dir]
+		stats.add(c)
+		r.Dirs[dir] = stats
+	}
+	return r
+}
+
+// dryRunChange builds a Change that keeps before and after for diffing when
+// both look like reasonably sized text.
+func dryRunChange(key string, action ChangeAction, before []byte, after []byte) Change {
+	c := Change{Path: key, Action: action}
+	if action == ActionUnchanged {
+		return c
+	}
+	if isDiffable(before) && isDiffable(after) {
+		c.before, c.after, c.diffable = before, after, true
+	}
+	return c
+}
+
+func isDiffable(data []byte) bool {
+	return len(data) <= maxDiffBytes && utf8.Valid(data) && !slices.Contains(data, 0)
+}
@@ -0,0 +1,167 @@
+package foundry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputDryRunLeavesDiskUntouched(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"index.html": "<h1>Home</h1>\n<p>old</p>\n",
+		"same.html":  "same\n",
+		"gone.html":  "bye\n",
+	})
+	src := filepath.Join(t.TempDir(), "logo.svg")
+	if err := os.WriteFile(src, []byte("<svg/>\n"), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+
+	out, err := NewOutput(root, OutputOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+	if err := out.WriteIfChanged("index.html", []byte("<h1>Home</h1>\n<p>new</p>\n")); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	if err := out.WriteIfChanged("same.html", []byte("same\n")); err != nil {
+		t.Fatalf("write same: %v", err)
+	}
+	if err := out.CopyFileIfChanged(src, "img/logo.svg"); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	removed, err := out.Prune()
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if want := []string{"gone.html"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removed got %v want %v", removed, want)
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "index.html"))
+	if err != nil || !strings.Contains(string(data), "old") {
+		t.Fatalf("dry run modified index.html: %q %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "img")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("dry run created img/, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "gone.html")); err != nil {
+		t.Fatalf("dry run removed gone.html: %v", err)
+	}
+
+	report := out.ChangeReport()
+	if got, want := report.Summary(), "3 files changed (1 created, 1 updated, 1 removed), 1 unchanged"; got != want {
+		t.Fatalf("summary got %q want %q", got, want)
+	}
+
+	var diff bytes.Buffer
+	if err := report.WriteDiff(&diff); err != nil {
+		t.Fatalf("WriteDiff: %v", err)
+	}
+	for _, want := range []string{
+		"--- a/index.html\n+++ b/index.html\n@@ -1,2 +1,2 @@\n <h1>Home</h1>\n-<p>old</p>\n+<p>new</p>\n",
+		"--- /dev/null\n+++ b/img/logo.svg\n@@ -0,0 +1 @@\n+<svg/>\n",
+		"--- a/gone.html\n+++ /dev/null\n@@ -1 +0,0 @@\n-bye\n",
+	} {
+		if !strings.Contains(diff.String(), want) {
+			t.Fatalf("diff missing %q:\n%s", want, diff.String())
+		}
+	}
+
+	var js bytes.Buffer
+	if err := report.WriteJSON(&js); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var decoded ChangeReport
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(decoded.Changes) != 4 || decoded.Changes[0].Path != "gone.html" || decoded.Changes[0].Action != ActionRemove {
+		t.Fatalf("unexpected decoded report %+v", decoded.Changes)
+	}
+}
+
+func TestOutputChangeReportRealRun(t *testing.T) {
+	out, err := NewOutputFS(NewMemFS(), OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := out.WriteIfChanged("a.html", []byte("a")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	report := out.ChangeReport()
+	if report.Count(ActionCreate) != 1 || report.Count(ActionUnchanged) != 1 {
+		t.Fatalf("unexpected report %+v", report.Changes)
+	}
+	var diff bytes.Buffer
+	if err := report.WriteDiff(&diff); err != nil {
+		t.Fatalf("WriteDiff: %v", err)
+	}
+	if got, want := diff.String(), "Files /dev/null and b/a.html differ\n"; got != want {
+		t.Fatalf("diff got %q want %q", got, want)
+	}
+}
+
+func TestChangeReportStats(t *testing.T) {
+	mem := NewMemFS()
+	if err := WriteIfChangedFS(mem, "blog/old.html", []byte("old")); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	if err := WriteIfChangedFS(mem, "blog/post.html", []byte("v1")); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	out, err := NewOutputFS(mem, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	for rel, content := range map[string]string{
+		"index.html":      "home",
+		"blog/post.html":  "version 2",
+		"css/site.css":    "body{}",
+		"css/unused.html": "",
+	} {
+		if err := out.WriteIfChanged(rel, []byte(content)); err != nil {
+			t.Fatalf("write %s: %v", rel, err)
+		}
+	}
+	// A repeated identical write is reported as unchanged.
+	if err := out.WriteIfChanged("css/unused.html", nil); err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if _, err := out.Prune(); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+
+	report := out.ChangeReport()
+	want := ChangeStats{Created: 3, Updated: 1, Unchanged: 1, Removed: 1, Changed: 5, BytesWritten: 4 + 9 + 6}
+	if report.Totals != want {
+		t.Fatalf("totals got %+v want %+v", report.Totals, want)
+	}
+	if got := report.Dirs["blog"]; got != (ChangeStats{Updated: 1, Removed: 1, Changed: 2, BytesWritten: 9}) {
+		t.Fatalf("blog stats got %+v", got)
+	}
+	if got := report.Dirs["."]; got.Created != 1 || got.BytesWritten != 4 {
+		t.Fatalf("root stats got %+v", got)
+	}
+
+	var js bytes.Buffer
+	if err := report.WriteJSON(&js); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var decoded ChangeReport
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoded.Totals != want || decoded.Dirs["css"].Created != 2 {
+		t.Fatalf("unexpected decoded stats %+v %+v", decoded.Totals, decoded.Dirs)
+	}
+}
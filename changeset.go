@@ -0,0 +1,256 @@
+package foundry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ChangesetEntry describes one path that differs between a deployed site and
+// a new build.
+type ChangesetEntry struct {
+	Path string `json:"path"`
+	// Action is ActionCreate, ActionUpdate or ActionRemove.
+	Action ChangeAction `json:"action"`
+	// Hash is the hex SHA-256 of the new content; Size is its length and
+	// ContentType its media type. All three are empty for removals.
+	Hash        string `json:"hash,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// Changeset lists the paths an incremental deploy has to upload or delete,
+// sorted by path.
+type Changeset struct {
+	Entries []ChangesetEntry `json:"entries"`
+}
+
+// Empty reports whether there is nothing to deploy.
+func (c *Changeset) Empty() bool {
+	return len(c.Entries) == 0
+}
+
+// Count returns the number of entries with the given action.
+func (c *Changeset) Count(action ChangeAction) int {
+	n := 0
+	for _, e := range c.Entries {
+		if e.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteJSON writes the changeset as indented JSON.
+func (c *Changeset) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c); err != nil {
+		return fmt.Errorf("foundry: encode changeset: %w", err)
+	}
+	return nil
+}
+
+// Apply updates m, a manifest describing the deployed site, to reflect a
+// completed deploy of c. Entries carry no modification time, so m is only
+// suitable for DiffManifest, not as an Output cache.
+func (c *Changeset) Apply(m *Manifest) {
+	for _, e := range c.Entries {
+		if e.Action == ActionRemove {
+			m.Delete(e.Path)
+			continue
+		}
+		m.Set(e.Path, ManifestEntry{Hash: e.Hash, Size: e.Size})
+	}
+}
+
+// DiffTrees compares the deployed tree oldFS with the new build newFS. Files
+// whose sizes differ are reported without hashing the old side.
+func DiffTrees(oldFS fs.FS, newFS fs.FS) (*Changeset, error) {
+	oldFiles, err := treeFiles(oldFS)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := treeFiles(newFS)
+	if err != nil {
+		return nil, err
+	}
+	cs := &Changeset{}
+	for name, info := range newFiles {
+		hash, err := hashFS(newFS, name)
+		if err != nil {
+			return nil, err
+		}
+		action := ActionCreate
+		if oldInfo, ok := oldFiles[name]; ok {
+			if oldInfo.Size() == info.Size() {
+				oldHash, err := hashFS(oldFS, name)
+				if err != nil {
+					return nil, err
+				}
+				if oldHash == hash {
+					continue
+				}
+			}
+			action = ActionUpdate
+		}
+		cs.Entries = append(cs.Entries, newChangesetEntry(name, action, hash, info.Size()))
+	}
+	for name := range oldFiles {
+		if _, ok := newFiles[name]; !ok {
+			cs.Entries = append(cs.Entries, ChangesetEntry{Path: name, Action: ActionRemove})
+		}
+	}
+	sortChangeset(cs)
+	return cs, nil
+}
+
+// DiffManifest compares a manifest of the deployed site, such as one kept up
+// to date with Changeset.Apply, with the new build in fsys. When build is the
+// Manifest the build wrote fsys with, hashes of files whose size and
+// modification time still match it are taken from build instead of being
+// recomputed. build may be nil.
+func DiffManifest(deployed *Manifest, fsys fs.FS, build *Manifest) (*Changeset, error) {
+	if deployed == nil {
+		return nil, errors.New("foundry: deployed manifest is nil")
+	}
+	files, err := treeFiles(fsys)
+	if err != nil {
+		return nil, err
+	}
+	cs := &Changeset{}
+	for name, info := range files {
+		hash, ok := build.cachedHash(name, info)
+		if !ok {
+			if hash, err = hashFS(fsys, name); err != nil {
+				return nil, err
+			}
+		}
+		action := ActionCreate
+		if entry, ok := deployed.Get(name); ok {
+			if entry.Hash == hash {
+				continue
+			}
+			action = ActionUpdate
+		}
+		cs.Entries = append(cs.Entries, newChangesetEntry(name, action, hash, info.Size()))
+	}
+	for _, name := range deployed.Paths() {
+		if _, ok := files[name]; !ok {
+			cs.Entries = append(cs.Entries, ChangesetEntry{Path: name, Action: ActionRemove})
+		}
+	}
+	sortChangeset(cs)
+	return cs, nil
+}
+
+// cachedHash returns the recorded hash of key when info still matches its
+// entry. It is safe to call on a nil Manifest.
+func (m *Manifest) cachedHash(key string, info fs.FileInfo) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	entry, ok := m.Get(key)
+	if !ok || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		return "", false
+	}
+	return entry.Hash, true
+}
+
+// webContentTypes pins the media types of common web formats, which
+// mime.TypeByExtension would otherwise take from the host's tables.
+var webContentTypes = map[string]string{
+	".avif":  "image/avif",
+	".css":   "text/css; charset=utf-8",
+	".gif":   "image/gif",
+	".gz":    "application/gzip",
+	".htm":   "text/html; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".ico":   "image/x-icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".map":   "application/json",
+	".mjs":   "text/javascript; charset=utf-8",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".txt":   "text/plain; charset=utf-8",
+	".wasm":  "application/wasm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "application/xml",
+}
+
+// ContentType returns the media type for name based on its extension, with
+// "application/octet-stream" for unknown extensions.
+func ContentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := webContentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func newChangesetEntry(name string, action ChangeAction, hash string, size int64) ChangesetEntry {
+	return ChangesetEntry{Path: name, Action: action, Hash: hash, Size: size, ContentType: ContentType(name)}
+}
+
+func sortChangeset(cs *Changeset) {
+	slices.SortFunc(cs.Entries, func(a, b ChangesetEntry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+}
+
+// treeFiles returns the regular files in fsys keyed by slash-separated path. A
+// missing root counts as an empty tree, as on a first deploy.
+func treeFiles(fsys fs.FS) (map[string]fs.FileInfo, error) {
+	files := make(map[string]fs.FileInfo)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if name == "." && errors.Is(err, fs.ErrNotExist) {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[name] = info
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("foundry: list files: %w", err)
+	}
+	return files, nil
+}
+
+func hashFS(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("foundry: open file: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("foundry: hash file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
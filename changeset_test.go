@@ -0,0 +1,122 @@
+package foundry
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDiffTrees(t *testing.T) {
+	oldFS := fstest.MapFS{
+		"index.html":     {Data: []byte("home")},
+		"about.html":     {Data: []byte("about")},
+		"css/site.css":   {Data: []byte("body{}")},
+		"img/gone.png":   {Data: []byte("png")},
+		"blog/same.html": {Data: []byte("same")},
+	}
+	newFS := fstest.MapFS{
+		"index.html":     {Data: []byte("HOME")},
+		"about.html":     {Data: []byte("about us")},
+		"css/site.css":   {Data: []byte("body{}")},
+		"blog/same.html": {Data: []byte("same")},
+		"feed.xml":       {Data: []byte("<rss/>")},
+	}
+	cs, err := DiffTrees(oldFS, newFS)
+	if err != nil {
+		t.Fatalf("DiffTrees: %v", err)
+	}
+	var got []string
+	for _, e := range cs.Entries {
+		got = append(got, e.Path+":"+e.Action.String())
+	}
+	want := []string{"about.html:update", "feed.xml:create", "img/gone.png:remove", "index.html:update"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("entries got %v want %v", got, want)
+	}
+	feed := cs.Entries[1]
+	if feed.Hash != hashBytes([]byte("<rss/>")) || feed.Size != 6 || feed.ContentType != "application/xml" {
+		t.Fatalf("unexpected entry %+v", feed)
+	}
+	if cs.Entries[2].Hash != "" || cs.Entries[2].ContentType != "" {
+		t.Fatalf("expected bare removal, got %+v", cs.Entries[2])
+	}
+
+	var js bytes.Buffer
+	if err := cs.WriteJSON(&js); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var decoded Changeset
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(decoded.Entries, cs.Entries) {
+		t.Fatalf("round trip got %+v", decoded.Entries)
+	}
+}
+
+func TestDiffManifestUsesBuildCache(t *testing.T) {
+	root := t.TempDir()
+	build := NewManifest()
+	out, err := NewOutput(root, OutputOptions{Manifest: build})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+	for rel, content := range map[string]string{"index.html": "home", "app.js": "run()"} {
+		if err := out.WriteIfChanged(rel, []byte(content)); err != nil {
+			t.Fatalf("write %s: %v", rel, err)
+		}
+	}
+
+	// First deploy: everything is new.
+	deployed := NewManifest()
+	cs, err := DiffManifest(deployed, os.DirFS(root), build)
+	if err != nil {
+		t.Fatalf("DiffManifest: %v", err)
+	}
+	if cs.Count(ActionCreate) != 2 {
+		t.Fatalf("expected two creations, got %+v", cs.Entries)
+	}
+	cs.Apply(deployed)
+
+	cs, err = DiffManifest(deployed, os.DirFS(root), build)
+	if err != nil {
+		t.Fatalf("DiffManifest: %v", err)
+	}
+	if !cs.Empty() {
+		t.Fatalf("expected no changes after apply, got %+v", cs.Entries)
+	}
+
+	// A poisoned cache entry proves cached hashes are trusted while the
+	// file's size and modification time match.
+	entry, _ := build.Get("app.js")
+	entry.Hash = "cached"
+	build.Set("app.js", entry)
+	if err := os.Remove(filepath.Join(root, "index.html")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	cs, err = DiffManifest(deployed, os.DirFS(root), build)
+	if err != nil {
+		t.Fatalf("DiffManifest: %v", err)
+	}
+	var got []string
+	for _, e := range cs.Entries {
+		got = append(got, e.Path+":"+e.Action.String()+":"+e.Hash)
+	}
+	if want := []string{"app.js:update:cached", "index.html:remove:"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("entries got %v want %v", got, want)
+	}
+}
+
+func TestDiffTreesFirstDeploy(t *testing.T) {
+	cs, err := DiffTrees(os.DirFS(filepath.Join(t.TempDir(), "missing")), fstest.MapFS{"a.txt": {Data: []byte("a")}})
+	if err != nil {
+		t.Fatalf("DiffTrees: %v", err)
+	}
+	if cs.Count(ActionCreate) != 1 || cs.Entries[0].ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected changeset %+v", cs.Entries)
+	}
+}
@@ -0,0 +1,487 @@
+package foundry
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// IgnoreFileName is the file CopyDirIfChanged reads exclude patterns from when
+// CopyDirOptions.ReadIgnoreFile is set.
+const IgnoreFileName = ".foundryignore"
+
+// SymlinkPolicy controls how CopyDirIfChanged treats symbolic links.
+type SymlinkPolicy int
+
+const (
+	// SymlinkFollow copies whatever the link points to. Linked directories
+	// are mirrored recursively; cycles are skipped.
+	SymlinkFollow SymlinkPolicy = iota
+	// SymlinkCopy recreates the link itself in the destination. Links whose
+	// target is absolute or climbs out of the copied tree are rejected with
+	// an *OutputPathError, since they would lead out of the output.
+	SymlinkCopy
+	// SymlinkSkip ignores links entirely.
+	SymlinkSkip
+)
+
+// CopyAction describes what CopyDirIfChanged did with a single path.
+type CopyAction int
+
+const (
+	// CopyCopied means the destination was created or updated.
+	CopyCopied CopyAction = iota + 1
+	// CopyUnchanged means the destination already matched the source.
+	CopyUnchanged
+	// CopySkipped means the path was filtered out and not copied.
+	CopySkipped
+)
+
+func (a CopyAction) String() string {
+	switch a {
+	case CopyCopied:
+		return "copied"
+	case CopyUnchanged:
+		return "unchanged"
+	case CopySkipped:
+		return "skipped"
+	default:
+		return fmt.Sprintf("CopyAction(%d)", int(a))
+	}
+}
+
+// CopyResult reports the outcome for one path, relative to the source root
+// and slash-separated. Skipped directories end in "/".
+type CopyResult struct {
+	Path   string
+	Action CopyAction
+}
+
+// CopyDirOptions configures CopyDirIfChanged.
+//
+// Patterns are slash-separated globs relative to the source root. "**"
+// matches any number of directories, a leading "/" or any inner "/" anchors a
+// pattern to the root, and a trailing "/" restricts it to directories. An
+// unanchored pattern such as "*.psd" matches at any depth.
+type CopyDirOptions struct {
+	// Include, when non-empty, limits the copy to files matching at least one
+	// pattern.
+	Include []string
+	// Exclude skips files and whole directories matching any pattern.
+	Exclude []string
+	// ReadIgnoreFile adds the patterns listed in IgnoreFileName at the
+	// source root to Exclude. Blank lines and lines starting with "#" are
+	// ignored. The ignore file itself is never copied.
+	ReadIgnoreFile bool
+	// Symlinks selects how symbolic links are handled.
+	Symlinks SymlinkPolicy
+	// Hardlink links files into the destination with LinkFileIfChanged
+	// instead of copying them. Only use it for read-only assets.
+	Hardlink bool
+	// Workers bounds the number of concurrent copies. Zero means
+	// runtime.NumCPU().
+	Workers int
+}
+
+// CopyDirIfChanged mirrors the tree at srcDir into dstDir in parallel, copying
+// each file with CopyFileIfChanged semantics. It returns one result per file
+// (and per excluded directory) sorted by path. Files are never removed from
+// dstDir; use Output.Prune for that.
+func CopyDirIfChanged(srcDir string, dstDir string, opts CopyDirOptions) ([]CopyResult, error) {
+	if dstDir == "" {
+		return nil, errors.New("foundry: copy destination is empty")
+	}
+	return copyDir(srcDir, opts, dirTarget(dstDir))
+}
+
+// CopyDirIfChanged mirrors srcDir into rel beneath the output root and records
+// every copied or unchanged file as part of the build. A rel of "" or "."
+// targets the output root itself.
+func (o *Output) CopyDirIfChanged(srcDir string, rel string, opts CopyDirOptions) ([]CopyResult, error) {
+	prefix := ""
+	if rel != "" && rel != "." {
+		key, err := o.resolve(rel)
+		if err != nil {
+			return nil, err
+		}
+		prefix = key
+	}
+	return copyDir(srcDir, opts, outputTarget{out: o, prefix: prefix})
+}
+
+// copyTarget receives the files planned by copyDir.
+type copyTarget interface {
+	copyFile(srcPath string, rel string) (ChangeAction, error)
+	hardlink(srcPath string, rel string) (ChangeAction, error)
+	link(target string, rel string) (ChangeAction, error)
+}
+
+type dirTarget string
+
+func (d dirTarget) copyFile(srcPath string, rel string) (ChangeAction, error) {
+	return copyFileIfChanged(osFS{}, srcPath, filepath.Join(string(d), filepath.FromSlash(rel)), FileAttrs{})
+}
+
+func (d dirTarget) hardlink(srcPath string, rel string) (ChangeAction, error) {
+	return linkFileIfChanged(srcPath, filepath.Join(string(d), filepath.FromSlash(rel)))
+}
+
+func (d dirTarget) link(target string, rel string) (ChangeAction, error) {
+	return linkIfChanged(target, filepath.Join(string(d), filepath.FromSlash(rel)))
+}
+
+type outputTarget struct {
+	out    *Output
+	prefix string
+}
+
+func (t outputTarget) copyFile(srcPath string, rel string) (ChangeAction, error) {
+	return t.out.copyFileIfChanged(srcPath, path.Join(t.prefix, rel))
+}
+
+func (t outputTarget) hardlink(srcPath string, rel string) (ChangeAction, error) {
+	return t.out.linkFileIfChanged(srcPath, path.Join(t.prefix, rel))
+}
+
+func (t outputTarget) link(target string, rel string) (ChangeAction, error) {
+	key, err := t.out.claim(path.Join(t.prefix, rel))
+	if err != nil {
+		return 0, err
+	}
+	p, ok, err := t.out.diskPath(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, errors.New("foundry: symbolic links require a disk output")
+	}
+	var action ChangeAction
+	if t.out.dryRun {
+		action, err = compareSymlink(target, p)
+	} else {
+		action, err = linkIfChanged(target, p)
+	}
+	if err != nil {
+		return 0, err
+	}
+	t.out.record(Change{Path: key, Action: action})
+	return action, nil
+}
+
+type copyJob struct {
+	src  string
+	rel  string
+	link string
+}
+
+func copyDir(srcDir string, opts CopyDirOptions, target copyTarget) ([]CopyResult, error) {
+	if srcDir == "" {
+		return nil, errors.New("foundry: copy source is empty")
+	}
+	info, err := os.Stat(srcDir)
+	if err != nil {
+		return nil, fmt.Errorf("foundry: stat source dir: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("foundry: source %s is not a directory", srcDir)
+	}
+
+	exclude := slices.Clone(opts.Exclude)
+	if opts.ReadIgnoreFile {
+		patterns, err := readIgnoreFile(filepath.Join(srcDir, IgnoreFileName))
+		if err != nil {
+			return nil, err
+		}
+		exclude = append(exclude, patterns...)
+	}
+	for _, pattern := range slices.Concat(opts.Include, exclude) {
+		if err := validateGlob(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	p := copyPlanner{opts: opts, exclude: exclude, visited: make(map[string]bool)}
+	if err := p.walk(srcDir, ""); err != nil {
+		return nil, err
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	var (
+		mu      sync.Mutex
+		results = p.skipped
+		errs    []error
+	)
+	err = ForEachParallel(p.jobs, workers, func(job copyJob) {
+		var action ChangeAction
+		var err error
+		switch {
+		case job.link != "":
+			action, err = target.link(job.link, job.rel)
+		case opts.Hardlink:
+			action, err = target.hardlink(job.src, job.rel)
+		default:
+			action, err = target.copyFile(job.src, job.rel)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("foundry: copy %s: %w", job.rel, err))
+			return
+		}
+		result := CopyResult{Path: job.rel, Action: CopyUnchanged}
+		if action != ActionUnchanged {
+			result.Action = CopyCopied
+		}
+		results = append(results, result)
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	slices.SortFunc(results, func(a, b CopyResult) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return results, errors.Join(errs...)
+}
+
+type copyPlanner struct {
+	opts    CopyDirOptions
+	exclude []string
+	visited map[string]bool
+	jobs    []copyJob
+	skipped []CopyResult
+}
+
+func (p *copyPlanner) walk(dir string, relDir string) error {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fmt.Errorf("foundry: resolve %s: %w", dir, err)
+	}
+	if p.visited[real] {
+		p.skip(relDir + "/")
+		return nil
+	}
+	p.visited[real] = true
+	defer delete(p.visited, real)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("foundry: read dir: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		rel := path.Join(relDir, name)
+		full := filepath.Join(dir, name)
+		if relDir == "" && p.opts.ReadIgnoreFile && name == IgnoreFileName {
+			continue
+		}
+
+		mode := entry.Type()
+		if mode&os.ModeSymlink != 0 {
+			switch p.opts.Symlinks {
+			case SymlinkSkip:
+				p.skip(rel)
+				continue
+			case SymlinkCopy:
+				if !p.wantFile(rel) {
+					p.skip(rel)
+					continue
+				}
+				linkTarget, err := os.Readlink(full)
+				if err != nil {
+					return fmt.Errorf("foundry: read link: %w", err)
+				}
+				if linkEscapes(rel, linkTarget) {
+					return &OutputPathError{Path: rel, Reason: fmt.Sprintf("symbolic link target %q leaves the copied tree", linkTarget)}
+				}
+				p.jobs = append(p.jobs, copyJob{src: full, rel: rel, link: linkTarget})
+				continue
+			}
+			info, err := os.Stat(full)
+			if err != nil {
+				return fmt.Errorf("foundry: follow link %s: %w", rel, err)
+			}
+			mode = info.Mode().Type()
+		}
+
+		switch {
+		case mode.IsDir():
+			if p.excluded(rel, true) {
+				p.skip(rel + "/")
+				continue
+			}
+			if err := p.walk(full, rel); err != nil {
+				return err
+			}
+		case mode.IsRegular() && p.wantFile(rel):
+			p.jobs = append(p.jobs, copyJob{src: full, rel: rel})
+		default:
+			p.skip(rel)
+		}
+	}
+	return nil
+}
+
+// linkEscapes reports whether a link at rel pointing to target resolves
+// outside the tree rel is relative to.
+func linkEscapes(rel string, target string) bool {
+	slashed := filepath.ToSlash(target)
+	if filepath.IsAbs(target) || path.IsAbs(slashed) || filepath.VolumeName(target) != "" {
+		return true
+	}
+	resolved := path.Join(path.Dir(rel), slashed)
+	return resolved == ".." || strings.HasPrefix(resolved, "../")
+}
+
+func (p *copyPlanner) wantFile(rel string) bool {
+	if p.excluded(rel, false) {
+		return false
+	}
+	if len(p.opts.Include) == 0 {
+		return true
+	}
+	for _, pattern := range p.opts.Include {
+		if matchGlob(pattern, rel, false) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *copyPlanner) excluded(rel string, isDir bool) bool {
+	for _, pattern := range p.exclude {
+		if matchGlob(pattern, rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *copyPlanner) skip(rel string) {
+	p.skipped = append(p.skipped, CopyResult{Path: rel, Action: CopySkipped})
+}
+
+func readIgnoreFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("foundry: open ignore file: %w", err)
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("foundry: read ignore file: %w", err)
+	}
+	return patterns, nil
+}
+
+// linkIfChanged points dst at target, replacing whatever is there unless it
+// is already a link to target.
+func linkIfChanged(target string, dst string) (ChangeAction, error) {
+	action, err := compareSymlink(target, dst)
+	if err != nil || action == ActionUnchanged {
+		return action, err
+	}
+	dir := filepath.Dir(dst)
+	if err := EnsureDir(dir); err != nil {
+		return 0, err
+	}
+	tmp := tempPath(dir)
+	if err := os.Symlink(target, tmp); err != nil {
+		return 0, fmt.Errorf("foundry: create link: %w", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return 0, fmt.Errorf("foundry: rename link: %w", err)
+	}
+	return action, nil
+}
+
+// compareSymlink reports what pointing dst at target would do.
+func compareSymlink(target string, dst string) (ChangeAction, error) {
+	existing, err := os.Readlink(dst)
+	if err == nil && existing == target {
+		return ActionUnchanged, nil
+	}
+	if _, err := os.Lstat(dst); errors.Is(err, os.ErrNotExist) {
+		return ActionCreate, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("foundry: stat existing file: %w", err)
+	}
+	return ActionUpdate, nil
+}
+
+// matchGlob reports whether the slash-separated path rel matches pattern
+// using the syntax documented on CopyDirOptions.
+func matchGlob(pattern string, rel string, isDir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	if strings.HasPrefix(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range len(name) + 1 {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func validateGlob(pattern string) error {
+	if strings.Trim(pattern, "/") == "" {
+		return fmt.Errorf("foundry: empty glob pattern %q", pattern)
+	}
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("foundry: invalid glob pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
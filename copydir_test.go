@@ -0,0 +1,180 @@
+package foundry
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		if err := WriteIfChanged(filepath.Join(root, filepath.FromSlash(rel)), []byte(content)); err != nil {
+			t.Fatalf("write %s: %v", rel, err)
+		}
+	}
+}
+
+func resultMap(results []CopyResult) map[string]CopyAction {
+	out := make(map[string]CopyAction, len(results))
+	for _, r := range results {
+		out[r.Path] = r.Action
+	}
+	return out
+}
+
+func TestCopyDirIfChangedFilters(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"css/site.css":         "body{}",
+		"css/vendor/reset.css": "*{}",
+		"img/logo.png":         "png",
+		"img/raw/logo.psd":     "psd",
+		"drafts/note.txt":      "draft",
+		IgnoreFileName:         "# editor files\n*.psd\n",
+	})
+
+	results, err := CopyDirIfChanged(src, dst, CopyDirOptions{
+		Exclude:        []string{"drafts/"},
+		ReadIgnoreFile: true,
+		Workers:        2,
+	})
+	if err != nil {
+		t.Fatalf("CopyDirIfChanged: %v", err)
+	}
+	want := map[string]CopyAction{
+		"css/site.css":         CopyCopied,
+		"css/vendor/reset.css": CopyCopied,
+		"drafts/":              CopySkipped,
+		"img/logo.png":         CopyCopied,
+		"img/raw/logo.psd":     CopySkipped,
+	}
+	if got := resultMap(results); !reflect.DeepEqual(got, want) {
+		t.Fatalf("results got %v want %v", got, want)
+	}
+	for _, rel := range []string{"drafts/note.txt", "img/raw/logo.psd", IgnoreFileName} {
+		if _, err := os.Stat(filepath.Join(dst, rel)); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected %s not to be copied, got %v", rel, err)
+		}
+	}
+
+	results, err = CopyDirIfChanged(src, dst, CopyDirOptions{Include: []string{"css/**/*.css"}})
+	if err != nil {
+		t.Fatalf("second copy: %v", err)
+	}
+	got := resultMap(results)
+	if got["css/site.css"] != CopyUnchanged || got["css/vendor/reset.css"] != CopyUnchanged {
+		t.Fatalf("expected css unchanged, got %v", got)
+	}
+	if got["img/logo.png"] != CopySkipped {
+		t.Fatalf("expected png skipped by include, got %v", got)
+	}
+}
+
+func TestCopyDirIfChangedSymlinks(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"real.txt": "data"})
+	if err := os.Symlink("real.txt", filepath.Join(src, "alias.txt")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+
+	followed := t.TempDir()
+	if _, err := CopyDirIfChanged(src, followed, CopyDirOptions{}); err != nil {
+		t.Fatalf("follow: %v", err)
+	}
+	info, err := os.Lstat(filepath.Join(followed, "alias.txt"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("expected regular file for followed link, got %v %v", info, err)
+	}
+
+	linked := t.TempDir()
+	if _, err := CopyDirIfChanged(src, linked, CopyDirOptions{Symlinks: SymlinkCopy}); err != nil {
+		t.Fatalf("copy links: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(linked, "alias.txt")); err != nil || target != "real.txt" {
+		t.Fatalf("expected link to real.txt, got %q %v", target, err)
+	}
+
+	skipped := t.TempDir()
+	results, err := CopyDirIfChanged(src, skipped, CopyDirOptions{Symlinks: SymlinkSkip})
+	if err != nil {
+		t.Fatalf("skip links: %v", err)
+	}
+	if got := resultMap(results)["alias.txt"]; got != CopySkipped {
+		t.Fatalf("expected alias skipped, got %v", got)
+	}
+}
+
+func TestCopyDirIfChangedRejectsEscapingLinks(t *testing.T) {
+	for name, target := range map[string]string{
+		"absolute": filepath.Join(t.TempDir(), "secret.txt"),
+		"parent":   "../../secret.txt",
+	} {
+		src := t.TempDir()
+		writeTree(t, src, map[string]string{"sub/real.txt": "data"})
+		if err := os.Symlink(target, filepath.Join(src, "sub", "alias.txt")); err != nil {
+			t.Skipf("symlinks unsupported: %v", err)
+		}
+		dst := t.TempDir()
+		_, err := CopyDirIfChanged(src, dst, CopyDirOptions{Symlinks: SymlinkCopy})
+		var pathErr *OutputPathError
+		if !errors.As(err, &pathErr) || pathErr.Path != "sub/alias.txt" {
+			t.Fatalf("%s: expected OutputPathError for sub/alias.txt, got %v", name, err)
+		}
+		if _, err := os.Lstat(filepath.Join(dst, "sub", "alias.txt")); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("%s: escaping link was written: %v", name, err)
+		}
+	}
+
+	// A link that climbs up but stays inside the tree is fine.
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"real.txt": "data", "sub/keep": ""})
+	if err := os.Symlink("../real.txt", filepath.Join(src, "sub", "alias.txt")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	if _, err := CopyDirIfChanged(src, t.TempDir(), CopyDirOptions{Symlinks: SymlinkCopy}); err != nil {
+		t.Fatalf("inside link: %v", err)
+	}
+}
+
+func TestOutputCopyDirIfChangedTracks(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"a.css": "a", "sub/b.js": "b"})
+
+	out, err := NewOutput(t.TempDir(), OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+	if _, err := out.CopyDirIfChanged(src, "static", CopyDirOptions{}); err != nil {
+		t.Fatalf("CopyDirIfChanged: %v", err)
+	}
+	if want := []string{"static/a.css", "static/sub/b.js"}; !reflect.DeepEqual(out.Touched(), want) {
+		t.Fatalf("touched got %v want %v", out.Touched(), want)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		{"*.psd", "a/b/c.psd", false, true},
+		{"/*.psd", "a/c.psd", false, false},
+		{"css/**/*.css", "css/site.css", false, true},
+		{"css/**/*.css", "css/a/b/site.css", false, true},
+		{"drafts/", "drafts", false, false},
+		{"drafts/", "x/drafts", true, true},
+		{"**", "anything/at/all", false, true},
+	}
+	for _, c := range cases {
+		if got := matchGlob(c.pattern, c.rel, c.isDir); got != c.want {
+			t.Fatalf("matchGlob(%q, %q) got %v want %v", c.pattern, c.rel, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,520 @@
+package foundry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// CSSBundleOptions configures BundleCSS.
+type CSSBundleOptions struct {
+	// SourceRoot is the directory whose layout the output mirrors, such as
+	// "static" when it is copied to the output root with CopyDir. Entries
+	// are relative to it, root-relative imports resolve against it, and
+	// url() references are rewritten to where the files they name are
+	// published. It defaults to the current directory.
+	SourceRoot string
+	// Assets, when set, fingerprints the bundle and points url() references
+	// at the fingerprinted versions of assets already written through it.
+	Assets *Assets
+	// SourceMap writes a version 3 source map next to the bundle, with the
+	// sources embedded, and links it from a sourceMappingURL comment. The map
+	// describes the bundle before OutputOptions.Transforms run, and CSS
+	// minification removes the comment, so only enable it with minification
+	// disabled.
+	SourceMap bool
+}
+
+// BundleCSS builds the stylesheet entry, a slash-separated path relative to
+// the source root, into a single file at the same path in out. Local
+// @imports are inlined recursively, each file once, with media queries kept
+// as @media blocks; remote @imports are hoisted to the top. Relative url()
+// references are rewritten to resolve from the bundle. It returns the output
+// path of the bundle, which is fingerprinted when opts.Assets is set.
+func BundleCSS(out *Output, entry string, opts CSSBundleOptions) (string, error) {
+	if out == nil {
+		return "", errors.New("foundry: css bundle output is nil")
+	}
+	if opts.SourceRoot == "" {
+		opts.SourceRoot = "."
+	}
+	root, err := filepath.Abs(opts.SourceRoot)
+	if err != nil {
+		return "", fmt.Errorf("foundry: resolve css source root: %w", err)
+	}
+	key, err := out.resolve(entry)
+	if err != nil {
+		return "", err
+	}
+
+	b := &cssBundle{root: root, key: key, assets: opts.Assets, seen: make(map[string]bool), lineStart: true}
+	if err := b.file(filepath.Join(root, filepath.FromSlash(key)), nil); err != nil {
+		return "", err
+	}
+	content, mapping := b.result()
+
+	mapKey := key + ".map"
+	if opts.SourceMap {
+		content += "\n/*# sourceMappingURL=" + path.Base(mapKey) + " */\n"
+	}
+	written := key
+	if opts.Assets != nil {
+		if written, err = opts.Assets.WriteFingerprinted(key, []byte(content)); err != nil {
+			return "", err
+		}
+	} else if err := out.WriteIfChanged(key, []byte(content)); err != nil {
+		return "", err
+	}
+	if opts.SourceMap {
+		data, err := b.sourceMap(mapKey, path.Base(written), mapping)
+		if err != nil {
+			return "", err
+		}
+		if err := out.WriteIfChanged(mapKey, data); err != nil {
+			return "", err
+		}
+	}
+	return written, nil
+}
+
+// cssOrigin is the source file index and line an output line starts at, or
+// -1 for generated lines.
+type cssOrigin struct {
+	src, line int
+}
+
+type cssBundle struct {
+	root   string
+	key    string
+	assets *Assets
+
+	// seen holds every stylesheet already inlined.
+	seen     map[string]bool
+	sources  []string
+	contents []string
+	// hoisted holds remote @import statements and charset the first
+	// @charset rule, both of which must precede everything else.
+	hoisted []string
+	charset string
+
+	body      strings.Builder
+	lines     []cssOrigin
+	lineStart bool
+}
+
+// file inlines the stylesheet at abs. chain lists the files importing it,
+// outermost first.
+func (b *cssBundle) file(abs string, chain []string) error {
+	if i := slices.Index(chain, abs); i >= 0 {
+		cycle := make([]string, 0, len(chain)-i+1)
+		for _, p := range append(chain[i:], abs) {
+			cycle = append(cycle, b.display(p))
+		}
+		return fmt.Errorf("foundry: css import cycle: %s", strings.Join(cycle, " -> "))
+	}
+	if b.seen[abs] {
+		return nil
+	}
+	b.seen[abs] = true
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return fmt.Errorf("foundry: read stylesheet: %w", err)
+	}
+	src := string(data)
+	idx := len(b.sources)
+	b.sources = append(b.sources, b.display(abs))
+	b.contents = append(b.contents, src)
+	chain = append(chain, abs)
+
+	s := cssScanner{src: src}
+	copied, copiedLine := 0, 0
+	flush := func(end int) {
+		b.emit(src[copied:end], idx, copiedLine)
+	}
+	depth := 0
+	for s.pos < len(src) {
+		start, line := s.pos, s.line
+		switch c := src[s.pos]; {
+		case strings.HasPrefix(src[s.pos:], "/*"):
+			s.skipComment()
+		case c == '"' || c == '\'':
+			s.skipString()
+		case c == '{':
+			depth++
+			s.next()
+		case c == '}':
+			depth--
+			s.next()
+		case depth == 0 && hasPrefixFold(src[s.pos:], "@charset"):
+			s.skipStatement()
+			s.skipLineEnd()
+			flush(start)
+			if b.charset == "" {
+				b.charset = strings.TrimSpace(src[start:s.pos])
+			}
+			copied, copiedLine = s.pos, s.line
+		case depth == 0 && hasPrefixFold(src[s.pos:], "@import"):
+			s.skipStatement()
+			rule := src[start:s.pos]
+			s.skipLineEnd()
+			flush(start)
+			if err := b.importRule(abs, rule, chain); err != nil {
+				return err
+			}
+			copied, copiedLine = s.pos, s.line
+		case hasPrefixFold(src[s.pos:], "url(") && (s.pos == 0 || !isCSSIdent(src[s.pos-1])):
+			ref, quote, ok := s.readURL()
+			if !ok {
+				continue
+			}
+			rewritten, err := b.rewriteURL(abs, ref)
+			if err != nil {
+				return fmt.Errorf("foundry: %s:%d: %w", b.display(abs), line+1, err)
+			}
+			flush(start)
+			b.emit("url("+quote+rewritten+quote+")", idx, line)
+			copied, copiedLine = s.pos, s.line
+		default:
+			s.next()
+		}
+	}
+	flush(len(src))
+	if !strings.HasSuffix(src, "\n") {
+		b.emit("\n", -1, 0)
+	}
+	return nil
+}
+
+// importRule inlines, or for remote URLs hoists, one @import statement.
+func (b *cssBundle) importRule(from string, rule string, chain []string) error {
+	target, conditions, err := parseImport(rule)
+	if err != nil {
+		return fmt.Errorf("foundry: %s: %w", b.display(from), err)
+	}
+	if u, err := url.Parse(target); err == nil && (u.Scheme != "" || u.Host != "") {
+		stmt := strings.TrimSpace(rule)
+		if !slices.Contains(b.hoisted, stmt) {
+			b.hoisted = append(b.hoisted, stmt)
+		}
+		return nil
+	}
+	if conditions != "" && (hasPrefixFold(conditions, "layer") || strings.Contains(strings.ToLower(conditions), "supports(")) {
+		return fmt.Errorf("foundry: %s: @import %q: layer and supports conditions are not supported", b.display(from), target)
+	}
+	target, _, _ = strings.Cut(target, "?")
+	var abs string
+	if strings.HasPrefix(target, "/") {
+		abs = filepath.Join(b.root, filepath.FromSlash(target))
+	} else {
+		abs = filepath.Join(filepath.Dir(from), filepath.FromSlash(target))
+	}
+	if b.seen[abs] && !slices.Contains(chain, abs) {
+		return nil
+	}
+	if conditions != "" {
+		b.emit("@media "+conditions+" {\n", -1, 0)
+	}
+	if err := b.file(abs, chain); err != nil {
+		return err
+	}
+	if conditions != "" {
+		b.emit("}\n", -1, 0)
+	}
+	return nil
+}
+
+// parseImport splits an @import statement into its URL and conditions.
+func parseImport(rule string) (string, string, error) {
+	rest := strings.TrimSpace(rule[len("@import"):])
+	rest = strings.TrimSpace(strings.TrimSuffix(rest, ";"))
+	s := cssScanner{src: rest}
+	var target string
+	switch {
+	case strings.HasPrefix(rest, `"`) || strings.HasPrefix(rest, "'"):
+		s.skipString()
+		target = rest[1 : s.pos-1]
+	case hasPrefixFold(rest, "url("):
+		var ok bool
+		if target, _, ok = s.readURL(); !ok {
+			return "", "", fmt.Errorf("malformed @import %q", strings.TrimSpace(rule))
+		}
+	default:
+		return "", "", fmt.Errorf("malformed @import %q", strings.TrimSpace(rule))
+	}
+	return target, strings.TrimSpace(rest[s.pos:]), nil
+}
+
+// rewriteURL maps a url() reference in the stylesheet from to the URL that
+// resolves to the same file from the bundle.
+func (b *cssBundle) rewriteURL(from string, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") || err != nil || u.Scheme != "" || u.Host != "" {
+		return ref, nil
+	}
+	suffix := ""
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		suffix = ref[i:]
+	}
+	var logical string
+	if strings.HasPrefix(u.Path, "/") {
+		logical = strings.TrimPrefix(path.Clean(u.Path), "/")
+	} else {
+		abs := filepath.Join(filepath.Dir(from), filepath.FromSlash(u.Path))
+		rel, err := filepath.Rel(b.root, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("url(%s) is outside the source root", ref)
+		}
+		logical = filepath.ToSlash(rel)
+	}
+	if b.assets != nil {
+		if entry, ok := b.assets.Lookup(logical); ok {
+			return entry.URL + suffix, nil
+		}
+	}
+	if strings.HasPrefix(u.Path, "/") {
+		return ref, nil
+	}
+	return relativeURL(path.Dir(b.key), logical) + suffix, nil
+}
+
+// relativeURL returns the relative URL of target from the directory dir,
+// both slash-separated paths relative to the same root.
+func relativeURL(dir string, target string) string {
+	var from []string
+	if dir != "." {
+		from = strings.Split(dir, "/")
+	}
+	to := strings.Split(target, "/")
+	common := 0
+	for common < len(from) && common < len(to)-1 && from[common] == to[common] {
+		common++
+	}
+	parts := make([]string, 0, len(from)-common+len(to)-common)
+	for range from[common:] {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, to[common:]...)
+	return (&url.URL{Path: strings.Join(parts, "/")}).EscapedPath()
+}
+
+// emit appends text taken from line of source src, or generated text when
+// src is -1, recording where each output line starts.
+func (b *cssBundle) emit(text string, src int, line int) {
+	for text != "" {
+		if b.lineStart {
+			b.lines = append(b.lines, cssOrigin{src: src, line: line})
+			b.lineStart = false
+		}
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			b.body.WriteString(text)
+			return
+		}
+		b.body.WriteString(text[:i+1])
+		text = text[i+1:]
+		line++
+		b.lineStart = true
+	}
+}
+
+// result returns the bundle and the origins of its lines, with the charset
+// and hoisted imports placed first.
+func (b *cssBundle) result() (string, []cssOrigin) {
+	var head []string
+	if b.charset != "" {
+		head = append(head, b.charset)
+	}
+	head = append(head, b.hoisted...)
+	origins := make([]cssOrigin, len(head), len(head)+len(b.lines))
+	for i := range origins {
+		origins[i] = cssOrigin{src: -1}
+	}
+	origins = append(origins, b.lines...)
+	prefix := ""
+	if len(head) > 0 {
+		prefix = strings.Join(head, "\n") + "\n"
+	}
+	return prefix + b.body.String(), origins
+}
+
+// sourceMap renders a version 3 source map, to be written at mapKey, with one
+// mapping per line. Sources resolve against the map's own URL, so they are
+// made relative to its directory.
+func (b *cssBundle) sourceMap(mapKey string, file string, origins []cssOrigin) ([]byte, error) {
+	sources := make([]string, len(b.sources))
+	for i, src := range b.sources {
+		sources[i] = relativeURL(path.Dir(mapKey), src)
+	}
+	var mappings strings.Builder
+	prevSrc, prevLine := 0, 0
+	for i, o := range origins {
+		if i > 0 {
+			mappings.WriteByte(';')
+		}
+		if o.src < 0 {
+			continue
+		}
+		writeVLQ(&mappings, 0)
+		writeVLQ(&mappings, o.src-prevSrc)
+		writeVLQ(&mappings, o.line-prevLine)
+		writeVLQ(&mappings, 0)
+		prevSrc, prevLine = o.src, o.line
+	}
+	data, err := json.Marshal(struct {
+		Version        int      `json:"version"`
+		File           string   `json:"file"`
+		Sources        []string `json:"sources"`
+		SourcesContent []string `json:"sourcesContent"`
+		Names          []string `json:"names"`
+		Mappings       string   `json:"mappings"`
+	}{3, file, sources, b.contents, []string{}, mappings.String()})
+	if err != nil {
+		return nil, fmt.Errorf("foundry: encode source map: %w", err)
+	}
+	return data, nil
+}
+
+const base64VLQ = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// writeVLQ appends v in the base64 VLQ encoding used by source maps.
+func writeVLQ(w *strings.Builder, v int) {
+	u := v << 1
+	if v < 0 {
+		u = -v<<1 | 1
+	}
+	for {
+		digit := u & 31
+		u >>= 5
+		if u > 0 {
+			digit |= 32
+		}
+		w.WriteByte(base64VLQ[digit])
+		if u == 0 {
+			return
+		}
+	}
+}
+
+// display returns abs relative to the source root for messages and source
+// map entries.
+func (b *cssBundle) display(abs string) string {
+	if rel, err := filepath.Rel(b.root, abs); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(abs)
+}
+
+// cssScanner walks a stylesheet, counting lines.
+type cssScanner struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (s *cssScanner) next() {
+	if s.src[s.pos] == '\n' {
+		s.line++
+	}
+	s.pos++
+}
+
+func (s *cssScanner) skipTo(end int) {
+	end = min(end, len(s.src))
+	s.line += strings.Count(s.src[s.pos:end], "\n")
+	s.pos = end
+}
+
+func (s *cssScanner) skipComment() {
+	if i := strings.Index(s.src[s.pos+2:], "*/"); i >= 0 {
+		s.skipTo(s.pos + 2 + i + 2)
+		return
+	}
+	s.skipTo(len(s.src))
+}
+
+func (s *cssScanner) skipString() {
+	s.skipTo(s.pos + quotedEnd([]byte(s.src[s.pos:]), 0))
+}
+
+// skipStatement moves past the ";" ending an at-rule statement, skipping
+// strings, comments and parentheses.
+func (s *cssScanner) skipStatement() {
+	for s.pos < len(s.src) {
+		switch c := s.src[s.pos]; {
+		case strings.HasPrefix(s.src[s.pos:], "/*"):
+			s.skipComment()
+		case c == '"' || c == '\'':
+			s.skipString()
+		case c == ';':
+			s.next()
+			return
+		case c == '{' || c == '}':
+			return
+		default:
+			s.next()
+		}
+	}
+}
+
+// skipLineEnd moves past trailing spaces and the newline after a statement
+// that is removed, so it does not leave a blank line behind.
+func (s *cssScanner) skipLineEnd() {
+	end := s.pos
+	for end < len(s.src) && (s.src[end] == ' ' || s.src[end] == '\t' || s.src[end] == '\r') {
+		end++
+	}
+	if end < len(s.src) && s.src[end] == '\n' {
+		s.skipTo(end + 1)
+	}
+}
+
+// readURL reads a url() token at the current position, returning its value
+// without quotes and the quote character used.
+func (s *cssScanner) readURL() (string, string, bool) {
+	start := s.pos
+	s.skipTo(s.pos + len("url("))
+	for s.pos < len(s.src) && isHTMLSpace(s.src[s.pos]) {
+		s.next()
+	}
+	if s.pos >= len(s.src) {
+		return "", "", false
+	}
+	var ref, quote string
+	if c := s.src[s.pos]; c == '"' || c == '\'' {
+		begin := s.pos
+		s.skipString()
+		quote = string(c)
+		ref = s.src[begin+1 : max(begin+1, s.pos-1)]
+	} else {
+		end := strings.IndexByte(s.src[s.pos:], ')')
+		if end < 0 {
+			s.skipTo(len(s.src))
+			return "", "", false
+		}
+		ref = strings.TrimSpace(s.src[s.pos : s.pos+end])
+		s.skipTo(s.pos + end)
+	}
+	for s.pos < len(s.src) && isHTMLSpace(s.src[s.pos]) {
+		s.next()
+	}
+	if s.pos >= len(s.src) || s.src[s.pos] != ')' {
+		s.skipTo(max(s.pos, start+1))
+		return "", "", false
+	}
+	s.next()
+	return ref, quote, true
+}
+
+func hasPrefixFold(s string, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func isCSSIdent(c byte) bool {
+	return isJSIdent(c) && c != '$' || c == '-'
+}
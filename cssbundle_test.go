@@ -0,0 +1,159 @@
+package foundry
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBundleCSS(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"css/main.css":          "@charset \"UTF-8\";\n@import \"partials/base.css\";\n@import url('print.css') print;\n@import url(https://fonts.example.com/css?family=Inter);\n.main { background: url(../img/hero.png); }\n",
+		"css/partials/base.css": "@import '../shared.css';\n.base { background: url(\"../../img/bg.png?v=2#x\"); }\n.mask { mask: url(#m); }\n",
+		"css/shared.css":        "@charset \"UTF-8\";\n.shared { background: url(data:image/png;base64,AAAA); }\n",
+		"css/print.css":         "@import \"shared.css\";\n.print { display: none }",
+	})
+	mem := NewMemFS()
+	out, err := NewOutputFS(mem, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+
+	written, err := BundleCSS(out, "css/main.css", CSSBundleOptions{SourceRoot: root})
+	if err != nil {
+		t.Fatalf("BundleCSS: %v", err)
+	}
+	if written != "css/main.css" {
+		t.Fatalf("written got %q", written)
+	}
+	want := `@charset "UTF-8";
+@import url(https://fonts.example.com/css?family=Inter);
+.shared { background: url(data:image/png;base64,AAAA); }
+.base { background: url("../img/bg.png?v=2#x"); }
+.mask { mask: url(#m); }
+@media print {
+.print { display: none }
+}
+.main { background: url(../img/hero.png); }
+`
+	if got := readMem(t, mem, "css/main.css"); got != want {
+		t.Fatalf("bundle got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBundleCSSFingerprintsAndSourceMap(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"css/site.css": "@import \"base.css\";\nh1 { background: url(/img/logo.png) }\n",
+		"css/base.css": "body { background: url(../img/bg.png) }\n",
+		"img/logo.png": "logo",
+		"img/bg.png":   "bg",
+	})
+	mem := NewMemFS()
+	out, err := NewOutputFS(mem, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	assets, err := NewAssets(out, AssetOptions{URLPrefix: "/static/"})
+	if err != nil {
+		t.Fatalf("NewAssets: %v", err)
+	}
+	logo, err := assets.CopyFingerprinted(root+"/img/logo.png", "img/logo.png")
+	if err != nil {
+		t.Fatalf("CopyFingerprinted: %v", err)
+	}
+
+	written, err := BundleCSS(out, "css/site.css", CSSBundleOptions{SourceRoot: root, Assets: assets, SourceMap: true})
+	if err != nil {
+		t.Fatalf("BundleCSS: %v", err)
+	}
+	entry, ok := assets.Lookup("css/site.css")
+	if !ok || entry.Path != written {
+		t.Fatalf("bundle not fingerprinted: %q %+v", written, entry)
+	}
+	got := readMem(t, mem, written)
+	want := "body { background: url(../img/bg.png) }\nh1 { background: url(/static/" + logo + ") }\n\n/*# sourceMappingURL=site.css.map */\n"
+	if got != want {
+		t.Fatalf("bundle got:\n%s\nwant:\n%s", got, want)
+	}
+
+	var sm struct {
+		Version        int      `json:"version"`
+		File           string   `json:"file"`
+		Sources        []string `json:"sources"`
+		SourcesContent []string `json:"sourcesContent"`
+		Mappings       string   `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(readMem(t, mem, "css/site.css.map")), &sm); err != nil {
+		t.Fatalf("decode source map: %v", err)
+	}
+	if sm.Version != 3 || sm.File != strings.TrimPrefix(written, "css/") {
+		t.Fatalf("unexpected source map header %+v", sm)
+	}
+	if len(sm.Sources) != 2 || len(sm.SourcesContent) != 2 {
+		t.Fatalf("unexpected sources %v", sm.Sources)
+	}
+	// Sources resolve against the map's URL, like devtools resolves them.
+	mapURL, err := url.Parse("https://example.com/css/site.css.map")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for i, want := range []string{"/css/site.css", "/css/base.css"} {
+		ref, err := url.Parse(sm.Sources[i])
+		if err != nil {
+			t.Fatalf("parse source: %v", err)
+		}
+		if got := mapURL.ResolveReference(ref).Path; got != want {
+			t.Errorf("source %q resolves to %q, want %q", sm.Sources[i], got, want)
+		}
+	}
+	// Line 1 is base.css line 1, line 2 is site.css line 2.
+	if sm.Mappings != "ACAA;ADCA" {
+		t.Fatalf("mappings got %q", sm.Mappings)
+	}
+}
+
+func TestBundleCSSErrors(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.css":       "@import \"b.css\";\n",
+		"b.css":       "@import \"a.css\";\n",
+		"layer.css":   "@import \"b2.css\" layer(base);\n",
+		"b2.css":      "p { color: red }\n",
+		"outside.css": "p { background: url(../x.png) }\n",
+		"missing.css": "@import \"nope.css\";\n",
+	})
+	out, err := NewOutputFS(NewMemFS(), OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	cases := map[string]string{
+		"a.css":       "css import cycle: a.css -> b.css -> a.css",
+		"layer.css":   "layer and supports conditions are not supported",
+		"outside.css": "outside.css:1: url(../x.png) is outside the source root",
+		"missing.css": "read stylesheet",
+	}
+	for entry, want := range cases {
+		_, err := BundleCSS(out, entry, CSSBundleOptions{SourceRoot: root})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want error containing %q", entry, err, want)
+		}
+	}
+}
+
+func TestRelativeURL(t *testing.T) {
+	cases := []struct{ dir, target, want string }{
+		{".", "img/a.png", "img/a.png"},
+		{"css", "img/a.png", "../img/a.png"},
+		{"css", "css/fonts/a.woff2", "fonts/a.woff2"},
+		{"a/b", "a/c/d.png", "../c/d.png"},
+		{"css", "img/my image.png", "../img/my%20image.png"},
+	}
+	for _, c := range cases {
+		if got := relativeURL(c.dir, c.target); got != c.want {
+			t.Errorf("relativeURL(%q, %q) got %q want %q", c.dir, c.target, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package foundry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Deployer publishes a built site. Deploy uploads every created or updated
+// entry of cs, reading content from src, and deletes every removed entry.
+// Implementations upload assets before HTML pages and delete last, so a page
+// is never live before the files it references or after they are gone.
+type Deployer interface {
+	Deploy(ctx context.Context, src fs.FS, cs *Changeset) error
+}
+
+// deployPhases splits cs into the batches a Deployer runs in order: assets,
+// HTML pages, then removals.
+func deployPhases(cs *Changeset) [][]ChangesetEntry {
+	var assets, pages, removals []ChangesetEntry
+	for _, e := range cs.Entries {
+		switch {
+		case e.Action == ActionRemove:
+			removals = append(removals, e)
+		case strings.HasPrefix(e.ContentType, "text/html"):
+			pages = append(pages, e)
+		default:
+			assets = append(assets, e)
+		}
+	}
+	return [][]ChangesetEntry{assets, pages, removals}
+}
+
+// LocalDeployer mirrors changesets into a directory, for example one served
+// by a web server or synced elsewhere. Files are replaced atomically and
+// directories emptied by removals are deleted.
+type LocalDeployer struct {
+	fsys *DiskFS
+}
+
+// NewLocalDeployer returns a Deployer targeting dir, which is created on the
+// first upload if needed.
+func NewLocalDeployer(dir string) *LocalDeployer {
+	return &LocalDeployer{fsys: NewDiskFS(dir)}
+}
+
+// Deploy implements Deployer.
+func (d *LocalDeployer) Deploy(ctx context.Context, src fs.FS, cs *Changeset) error {
+	if src == nil || cs == nil {
+		return errors.New("foundry: deploy source and changeset must be non-nil")
+	}
+	for _, phase := range deployPhases(cs) {
+		for _, e := range phase {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := d.apply(src, e); err != nil {
+				return fmt.Errorf("foundry: deploy %s: %w", e.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *LocalDeployer) apply(src fs.FS, e ChangesetEntry) error {
+	if e.Action != ActionRemove {
+		f, err := src.Open(e.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return d.fsys.WriteFile(e.Path, f, FileAttrs{})
+	}
+	if err := d.fsys.Remove(e.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := path.Dir(e.Path); dir != "."; dir = path.Dir(dir) {
+		entries, err := d.fsys.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			break
+		}
+		if err := d.fsys.Remove(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
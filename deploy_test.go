@@ -0,0 +1,56 @@
+package foundry
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLocalDeployer(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "public")
+	writeTree(t, dest, map[string]string{
+		"index.html":      "old home",
+		"old/gone.html":   "gone",
+		"css/site.css":    "body{}",
+		"keep/extra.html": "not in changeset",
+	})
+	deployed := fstest.MapFS{
+		"index.html":    {Data: []byte("old home")},
+		"old/gone.html": {Data: []byte("gone")},
+		"css/site.css":  {Data: []byte("body{}")},
+	}
+	build := fstest.MapFS{
+		"index.html":   {Data: []byte("new home")},
+		"css/site.css": {Data: []byte("body{}")},
+		"img/logo.svg": {Data: []byte("<svg/>")},
+	}
+	cs, err := DiffTrees(deployed, build)
+	if err != nil {
+		t.Fatalf("DiffTrees: %v", err)
+	}
+	if err := NewLocalDeployer(dest).Deploy(context.Background(), build, cs); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	assertContent(t, filepath.Join(dest, "index.html"), "new home")
+	assertContent(t, filepath.Join(dest, "img", "logo.svg"), "<svg/>")
+	assertContent(t, filepath.Join(dest, "keep", "extra.html"), "not in changeset")
+	if _, err := os.Stat(filepath.Join(dest, "old")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected emptied directory to be removed, got %v", err)
+	}
+}
+
+func TestDeployPhasesOrder(t *testing.T) {
+	cs := &Changeset{Entries: []ChangesetEntry{
+		{Path: "a.html", Action: ActionCreate, ContentType: ContentType("a.html")},
+		{Path: "b.css", Action: ActionUpdate, ContentType: ContentType("b.css")},
+		{Path: "c.js", Action: ActionRemove},
+	}}
+	phases := deployPhases(cs)
+	if len(phases) != 3 || phases[0][0].Path != "b.css" || phases[1][0].Path != "a.html" || phases[2][0].Path != "c.js" {
+		t.Fatalf("unexpected phases %+v", phases)
+	}
+}
@@ -0,0 +1,54 @@
+package foundry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiffHunks(t *testing.T) {
+	var before, after []string
+	for i := 1; i <= 20; i++ {
+		line := "line " + string(rune('a'+i-1))
+		before = append(before, line)
+		switch i {
+		case 2:
+			after = append(after, "changed b")
+		case 18:
+			// dropped
+		default:
+			after = append(after, line)
+		}
+	}
+	got := unifiedDiff("a/x", "b/x", strings.Join(before, "\n")+"\n", strings.Join(after, "\n")+"\n")
+	want := `--- a/x
++++ b/x
+@@ -1,5 +1,5 @@
+ line a
+-line b
++changed b
+ line c
+ line d
+ line e
+@@ -15,6 +15,5 @@
+ line o
+ line p
+ line q
+-line r
+ line s
+ line t
+`
+	if got != want {
+		t.Fatalf("diff mismatch:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedDiffMissingNewline(t *testing.T) {
+	got := unifiedDiff("a/x", "b/x", "one\ntwo", "one\ntwo\n")
+	want := "--- a/x\n+++ b/x\n@@ -1,2 +1,2 @@\n one\n-two\n\\ No newline at end of file\n+two\n"
+	if got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if unifiedDiff("a", "b", "same", "same") != "" {
+		t.Fatalf("expected empty diff for equal input")
+	}
+}
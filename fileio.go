@@ -8,85 +8,179 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sync/atomic"
+	"time"
 )
 
 // WriteIfChanged ensures path exists and only writes the provided content to path
 // when the bytes differ from the existing file. Writes are performed atomically
-// to avoid partially written files when the process is interrupted.
+// to avoid partially written files when the process is interrupted. New files
+// get mode 0644 filtered by the process umask.
 func WriteIfChanged(path string, content []byte) error {
-	if path == "" {
-		return errors.New("foundry: write path is empty")
-	}
+	_, err := writeIfChanged(osFS{}, path, content, FileAttrs{})
+	return err
+}
 
-	dir := filepath.Dir(path)
-	if dir != "." && dir != "" {
-		if err := EnsureDir(dir); err != nil {
-			return err
-		}
+// writeIfChanged implements WriteIfChanged for any OutputFS and reports what
+// happened to name. The explicit parts of attrs are applied even when the
+// content is unchanged.
+func writeIfChanged(fsys OutputFS, name string, content []byte, attrs FileAttrs) (ChangeAction, error) {
+	action, _, err := compareBytes(fsys, name, content)
+	if err != nil {
+		return 0, err
+	}
+	if action == ActionUnchanged {
+		return action, fixAttrs(fsys, name, attrs)
 	}
+	if err := fsys.WriteFile(name, bytes.NewReader(content), attrs); err != nil {
+		return 0, err
+	}
+	return action, nil
+}
 
-	var existing []byte
-	var err error
-	existing, err = os.ReadFile(path)
-	if err != nil && !errors.Is(err, fs.ErrNotExist) {
-		return fmt.Errorf("foundry: read existing file: %w", err)
+// compareBytes reports what writing content to name would do, along with the
+// existing content when there is any.
+func compareBytes(fsys fs.FS, name string, content []byte) (ChangeAction, []byte, error) {
+	if name == "" {
+		return 0, nil, errors.New("foundry: write path is empty")
 	}
-	if err == nil && bytes.Equal(existing, content) {
-		return nil
+	existing, err := fs.ReadFile(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ActionCreate, nil, nil
 	}
+	if err != nil {
+		if info, statErr := fs.Stat(fsys, name); statErr == nil && info.IsDir() {
+			return 0, nil, &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+		}
+		return 0, nil, fmt.Errorf("foundry: read existing file: %w", err)
+	}
+	if bytes.Equal(existing, content) {
+		return ActionUnchanged, existing, nil
+	}
+	return ActionUpdate, existing, nil
+}
 
-	tempFile, err := os.CreateTemp(dirOrDot(dir), ".foundry-*")
+// CopyFileIfChanged copies the file from srcPath to dstPath only if the
+// destination content differs from the source. The comparison and the copy are
+// streamed in fixed-size chunks so large assets are never held in memory.
+func CopyFileIfChanged(srcPath string, dstPath string) error {
+	_, err := copyFileIfChanged(osFS{}, srcPath, dstPath, FileAttrs{})
+	return err
+}
+
+// copyFileIfChanged implements CopyFileIfChanged for any OutputFS and reports
+// what happened to name. The explicit parts of attrs are applied even when the
+// content is unchanged.
+func copyFileIfChanged(fsys OutputFS, srcPath string, name string, attrs FileAttrs) (ChangeAction, error) {
+	src, info, err := openSource(srcPath, name)
 	if err != nil {
-		return fmt.Errorf("foundry: create temp file: %w", err)
+		return 0, err
 	}
-	defer func() {
-		_ = os.Remove(tempFile.Name())
-	}()
+	defer src.Close()
 
-	if _, err := tempFile.Write(content); err != nil {
-		_ = tempFile.Close()
-		return fmt.Errorf("foundry: write temp file: %w", err)
+	action, err := compareContent(src, info.Size(), fsys, name)
+	if err != nil {
+		return 0, err
 	}
-	if err := tempFile.Sync(); err != nil {
-		_ = tempFile.Close()
-		return fmt.Errorf("foundry: sync temp file: %w", err)
+	if action == ActionUnchanged {
+		return action, fixAttrs(fsys, name, attrs)
 	}
-	if err := tempFile.Close(); err != nil {
-		return fmt.Errorf("foundry: close temp file: %w", err)
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("foundry: rewind source file: %w", err)
+	}
+	if err := fsys.WriteFile(name, src, attrs); err != nil {
+		return 0, err
 	}
+	return action, nil
+}
 
-	if err := os.Rename(tempFile.Name(), path); err != nil {
-		return fmt.Errorf("foundry: rename temp file: %w", err)
+// compareFile reports what copying srcPath to name would do without writing.
+func compareFile(fsys fs.FS, srcPath string, name string) (ChangeAction, error) {
+	src, info, err := openSource(srcPath, name)
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	defer src.Close()
+	return compareContent(src, info.Size(), fsys, name)
 }
 
-// CopyFileIfChanged copies the file from srcPath to dstPath only if the
-// destination content differs from the source.
-func CopyFileIfChanged(srcPath string, dstPath string) error {
-	if srcPath == "" || dstPath == "" {
-		return errors.New("foundry: copy paths must be non-empty")
+func openSource(srcPath string, name string) (*os.File, fs.FileInfo, error) {
+	if srcPath == "" || name == "" {
+		return nil, nil, errors.New("foundry: copy paths must be non-empty")
 	}
 	src, err := os.Open(srcPath)
 	if err != nil {
-		return fmt.Errorf("foundry: open source file: %w", err)
+		return nil, nil, fmt.Errorf("foundry: open source file: %w", err)
 	}
-	defer src.Close()
-
 	info, err := src.Stat()
 	if err != nil {
-		return fmt.Errorf("foundry: stat source file: %w", err)
+		_ = src.Close()
+		return nil, nil, fmt.Errorf("foundry: stat source file: %w", err)
 	}
 	if info.IsDir() {
-		return fmt.Errorf("foundry: source %s is a directory", srcPath)
+		_ = src.Close()
+		return nil, nil, fmt.Errorf("foundry: source %s is a directory", srcPath)
 	}
+	return src, info, nil
+}
 
-	data, err := io.ReadAll(src)
-	if err != nil {
-		return fmt.Errorf("foundry: read source file: %w", err)
+// LinkFileIfChanged makes dstPath a hard link to srcPath unless it already is
+// one. Linking avoids copying large read-only assets that live on the same
+// filesystem as the output; when a link cannot be created (for example across
+// devices) it falls back to CopyFileIfChanged. Because every foundry write
+// replaces files by rename, later writes to dstPath never modify srcPath, but
+// editing srcPath in place is visible through dstPath.
+func LinkFileIfChanged(srcPath string, dstPath string) error {
+	_, err := linkFileIfChanged(srcPath, dstPath)
+	return err
+}
+
+func linkFileIfChanged(srcPath string, dstPath string) (ChangeAction, error) {
+	action, err := compareLink(srcPath, dstPath)
+	if err != nil || action == ActionUnchanged {
+		return action, err
 	}
 
-	return WriteIfChanged(dstPath, data)
+	dir := filepath.Dir(dstPath)
+	if dir != "." && dir != "" {
+		if err := EnsureDir(dir); err != nil {
+			return 0, err
+		}
+	}
+	tmp := tempPath(dir)
+	if err := os.Link(srcPath, tmp); err != nil {
+		return copyFileIfChanged(osFS{}, srcPath, dstPath, FileAttrs{})
+	}
+	if err := os.Rename(tmp, dstPath); err != nil {
+		_ = os.Remove(tmp)
+		return 0, fmt.Errorf("foundry: rename link: %w", err)
+	}
+	return action, nil
+}
+
+// compareLink reports what hard linking srcPath to dstPath would do.
+func compareLink(srcPath string, dstPath string) (ChangeAction, error) {
+	if srcPath == "" || dstPath == "" {
+		return 0, errors.New("foundry: link paths must be non-empty")
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return 0, fmt.Errorf("foundry: stat source file: %w", err)
+	}
+	if info.IsDir() {
+		return 0, fmt.Errorf("foundry: source %s is a directory", srcPath)
+	}
+	existing, err := os.Stat(dstPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return ActionCreate, nil
+	case err != nil:
+		return 0, fmt.Errorf("foundry: stat existing file: %w", err)
+	case os.SameFile(info, existing):
+		return ActionUnchanged, nil
+	default:
+		return ActionUpdate, nil
+	}
 }
 
 // EnsureDir creates the directory path (and parents) if it does not exist.
@@ -100,6 +194,147 @@ func EnsureDir(dir string) error {
 	return nil
 }
 
+// fileOps is the subset of file operations shared by *os.Root and the host
+// filesystem, so that atomic writes behave the same inside and outside a root.
+type fileOps interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error)
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	Rename(oldpath string, newpath string) error
+	Remove(name string) error
+}
+
+// hostOps implements fileOps with ordinary paths.
+type hostOps struct{}
+
+func (hostOps) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (hostOps) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (hostOps) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (hostOps) Rename(oldpath string, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (hostOps) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// writeAtomic streams r into a temporary file beside path and renames it into
+// place, so readers never observe a partially written file. The temporary file
+// is created with the requested mode (0644 by default) so the umask applies as
+// it would to a direct write; an explicit mode is then set exactly.
+func writeAtomic(ops fileOps, path string, r io.Reader, attrs FileAttrs) error {
+	tmp, err := writeTemp(ops, filepath.Dir(path), r, attrs)
+	if err != nil {
+		return err
+	}
+	return renameTemp(ops, tmp, path)
+}
+
+// writeTemp streams r into a new temporary file in dir carrying attrs and
+// returns its path. The file is removed again if anything fails.
+func writeTemp(ops fileOps, dir string, r io.Reader, attrs FileAttrs) (string, error) {
+	perm := defaultFileMode
+	if attrs.Mode != 0 {
+		perm = attrs.Mode.Perm()
+	}
+	tmp := tempPath(dir)
+	tempFile, err := ops.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return "", fmt.Errorf("foundry: create temp file: %w", err)
+	}
+
+	if _, err := io.Copy(tempFile, r); err != nil {
+		_ = tempFile.Close()
+		_ = ops.Remove(tmp)
+		return "", fmt.Errorf("foundry: write temp file: %w", err)
+	}
+	if err := tempFile.Sync(); err != nil {
+		_ = tempFile.Close()
+		_ = ops.Remove(tmp)
+		return "", fmt.Errorf("foundry: sync temp file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		_ = ops.Remove(tmp)
+		return "", fmt.Errorf("foundry: close temp file: %w", err)
+	}
+	if err := setDiskAttrs(ops, tmp, attrs); err != nil {
+		_ = ops.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+// renameTemp moves a file made by writeTemp to path, removing it on failure.
+func renameTemp(ops fileOps, tmp string, path string) error {
+	if err := ops.Rename(tmp, path); err != nil {
+		_ = ops.Remove(tmp)
+		return fmt.Errorf("foundry: rename temp file: %w", err)
+	}
+	return nil
+}
+
+const compareChunkSize = 64 << 10
+
+// compareContent reports what replacing the file name in fsys with the size
+// bytes readable from r would do. Sizes are compared first so that most
+// changed files are detected without reading either side.
+func compareContent(r io.Reader, size int64, fsys fs.FS, name string) (ChangeAction, error) {
+	dst, err := fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ActionCreate, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("foundry: read existing file: %w", err)
+	}
+	defer dst.Close()
+
+	info, err := dst.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("foundry: stat existing file: %w", err)
+	}
+	if !info.Mode().IsRegular() || info.Size() != size {
+		return ActionUpdate, nil
+	}
+
+	bufA := make([]byte, compareChunkSize)
+	bufB := make([]byte, compareChunkSize)
+	for {
+		n, errA := io.ReadFull(r, bufA)
+		m, errB := io.ReadFull(dst, bufB)
+		if n != m || !bytes.Equal(bufA[:n], bufB[:m]) {
+			return ActionUpdate, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			if errB == io.EOF || errB == io.ErrUnexpectedEOF {
+				return ActionUnchanged, nil
+			}
+			return ActionUpdate, nil
+		}
+		if errA != nil {
+			return 0, fmt.Errorf("foundry: read source file: %w", errA)
+		}
+		if errB != nil {
+			return 0, fmt.Errorf("foundry: read existing file: %w", errB)
+		}
+	}
+}
+
+var tempCounter atomic.Uint64
+
+// tempPath returns a fresh name for a temporary entry inside dir.
+func tempPath(dir string) string {
+	return filepath.Join(dirOrDot(dir), fmt.Sprintf(".foundry-%d-%d", os.Getpid(), tempCounter.Add(1)))
+}
+
 func dirOrDot(dir string) string {
 	if dir == "" || dir == "." {
 		return "."
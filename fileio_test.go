@@ -1,6 +1,7 @@
 package foundry
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"testing"
@@ -130,3 +131,92 @@ func TestEnsureDir(t *testing.T) {
 		t.Fatalf("expected directory, got file")
 	}
 }
+
+func TestCopyFileIfChangedLargeSameSizeDifferentContent(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "big.bin")
+	dst := filepath.Join(dir, "out", "big.bin")
+
+	data := make([]byte, 3*compareChunkSize+17)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if err := os.WriteFile(src, data, 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+	if err := CopyFileIfChanged(src, dst); err != nil {
+		t.Fatalf("first copy: %v", err)
+	}
+
+	// Flip a byte in the final partial chunk; sizes stay equal.
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(src, data, 0o644); err != nil {
+		t.Fatalf("rewrite src: %v", err)
+	}
+	action, err := copyFileIfChanged(osFS{}, src, dst, FileAttrs{})
+	if err != nil {
+		t.Fatalf("second copy: %v", err)
+	}
+	if action != ActionUpdate {
+		t.Fatalf("expected differing content to be copied")
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("read dst: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("destination does not match source")
+	}
+
+	action, err = copyFileIfChanged(osFS{}, src, dst, FileAttrs{})
+	if err != nil {
+		t.Fatalf("third copy: %v", err)
+	}
+	if action != ActionUnchanged {
+		t.Fatalf("expected identical content to be left alone")
+	}
+}
+
+func TestLinkFileIfChanged(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "video.mp4")
+	dst := filepath.Join(dir, "dist", "video.mp4")
+	if err := os.WriteFile(src, []byte("frames"), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+
+	if err := LinkFileIfChanged(src, dst); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		t.Fatalf("stat src: %v", err)
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("stat dst: %v", err)
+	}
+	if !os.SameFile(srcInfo, dstInfo) {
+		t.Skipf("hard links unsupported here; fell back to copy")
+	}
+
+	action, err := linkFileIfChanged(src, dst)
+	if err != nil {
+		t.Fatalf("relink: %v", err)
+	}
+	if action != ActionUnchanged {
+		t.Fatalf("expected existing link to be left alone")
+	}
+
+	// Rewriting the output must not modify the linked source.
+	if err := WriteIfChanged(dst, []byte("replaced")); err != nil {
+		t.Fatalf("overwrite dst: %v", err)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatalf("read src: %v", err)
+	}
+	if string(data) != "frames" {
+		t.Fatalf("source modified through link: %q", data)
+	}
+}
@@ -6,5 +6,6 @@ toolchain go1.25.3
 
 require (
 	github.com/yuin/goldmark v1.7.13
+	golang.org/x/sys v0.38.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,143 @@
+package foundry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+const defaultGzipMinSize = 1024
+
+// defaultGzipExtensions lists the text formats that benefit from
+// precompression.
+var defaultGzipExtensions = []string{".html", ".htm", ".css", ".js", ".mjs", ".svg", ".json", ".xml", ".txt"}
+
+// GzipOptions configures the precompressed ".gz" companions an Output writes
+// beside text outputs, as served by nginx gzip_static and most CDNs.
+type GzipOptions struct {
+	// MinSize is the smallest output, in bytes, that gets a companion.
+	// Smaller files compress poorly. Zero selects 1024.
+	MinSize int64
+	// Extensions lists the file extensions, including the dot, that
+	// qualify. It defaults to HTML, CSS, JavaScript, SVG, JSON, XML and plain
+	// text.
+	Extensions []string
+	// Level is a compress/gzip level. Zero selects gzip.BestCompression,
+	// since companions are written once and served many times.
+	Level int
+}
+
+// normalize validates o and fills in defaults. A nil o disables companions.
+func (o *GzipOptions) normalize() (*GzipOptions, error) {
+	if o == nil {
+		return nil, nil
+	}
+	gz := &GzipOptions{MinSize: o.MinSize, Level: o.Level}
+	if gz.MinSize < 0 {
+		return nil, fmt.Errorf("foundry: gzip minimum size must not be negative (got %d)", gz.MinSize)
+	}
+	if gz.MinSize == 0 {
+		gz.MinSize = defaultGzipMinSize
+	}
+	if gz.Level == 0 {
+		gz.Level = gzip.BestCompression
+	}
+	if gz.Level < gzip.HuffmanOnly || gz.Level > gzip.BestCompression {
+		return nil, fmt.Errorf("foundry: invalid gzip level %d", gz.Level)
+	}
+	exts := o.Extensions
+	if len(exts) == 0 {
+		exts = defaultGzipExtensions
+	}
+	for _, ext := range exts {
+		if !strings.HasPrefix(ext, ".") || ext == ".gz" {
+			return nil, fmt.Errorf("foundry: invalid gzip extension %q", ext)
+		}
+		gz.Extensions = append(gz.Extensions, strings.ToLower(ext))
+	}
+	return gz, nil
+}
+
+// matches reports whether key is a file type that gets a companion.
+func (o *GzipOptions) matches(key string) bool {
+	return slices.Contains(o.Extensions, strings.ToLower(path.Ext(key)))
+}
+
+// precompress writes or removes the companion of key after content was
+// written to it.
+func (o *Output) precompress(key string, content []byte) error {
+	if o.gzip == nil || !o.gzip.matches(key) {
+		return nil
+	}
+	if int64(len(content)) < o.gzip.MinSize {
+		return o.removeCompanion(key + ".gz")
+	}
+	data, err := gzipBytes(content, o.gzip.Level)
+	if err != nil {
+		return err
+	}
+	_, err = o.writeIfChanged(key+".gz", data)
+	return err
+}
+
+// precompressFile is precompress for an output copied from srcPath.
+func (o *Output) precompressFile(key string, srcPath string) error {
+	if o.gzip == nil || !o.gzip.matches(key) {
+		return nil
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return fmt.Errorf("foundry: stat source file: %w", err)
+	}
+	if info.Size() < o.gzip.MinSize {
+		return o.removeCompanion(key + ".gz")
+	}
+	content, err := os.ReadFile(srcPath)
+	if err != nil {
+		return fmt.Errorf("foundry: read source file: %w", err)
+	}
+	return o.precompress(key, content)
+}
+
+// removeCompanion deletes a companion left over from a build in which its
+// output still qualified.
+func (o *Output) removeCompanion(key string) error {
+	if _, err := o.fsys.Stat(key); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("foundry: stat gzip companion: %w", err)
+	}
+	if !o.dryRun {
+		if err := o.fsys.Remove(key); err != nil {
+			return fmt.Errorf("foundry: remove gzip companion: %w", err)
+		}
+		if o.manifest != nil {
+			o.manifest.Delete(key)
+		}
+	}
+	o.recordRemoved(Change{Path: key, Action: ActionRemove})
+	return nil
+}
+
+// gzipBytes compresses data with a fixed header (no name, zero modification
+// time) so identical input always yields identical output.
+func gzipBytes(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, fmt.Errorf("foundry: gzip: %w", err)
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("foundry: gzip: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("foundry: gzip: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package foundry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputGzipCompanions(t *testing.T) {
+	mem := NewMemFS()
+	page := []byte(strings.Repeat("<p>hello</p>\n", 200))
+	build := func(content []byte) *Output {
+		t.Helper()
+		out, err := NewOutputFS(mem, OutputOptions{Gzip: &GzipOptions{}})
+		if err != nil {
+			t.Fatalf("NewOutputFS: %v", err)
+		}
+		if err := out.WriteIfChanged("index.html", content); err != nil {
+			t.Fatalf("write index: %v", err)
+		}
+		if err := out.WriteIfChanged("logo.png", page); err != nil {
+			t.Fatalf("write png: %v", err)
+		}
+		return out
+	}
+
+	first := build(page)
+	if got := first.Touched(); !reflect.DeepEqual(got, []string{"index.html", "index.html.gz", "logo.png"}) {
+		t.Fatalf("touched got %v", got)
+	}
+	compressed, err := fs.ReadFile(mem, "index.html.gz")
+	if err != nil {
+		t.Fatalf("read companion: %v", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("open companion: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil || !bytes.Equal(data, page) {
+		t.Fatalf("companion does not round trip: %v", err)
+	}
+	if zr.Name != "" || !zr.ModTime.IsZero() {
+		t.Fatalf("expected empty gzip header, got %q %s", zr.Name, zr.ModTime)
+	}
+
+	second := build(page)
+	if got := second.ChangeReport().Count(ActionUnchanged); got != 3 {
+		t.Fatalf("expected rebuild to leave all files unchanged, got %+v", second.ChangeReport().Changes)
+	}
+
+	third := build([]byte("<p>tiny</p>"))
+	if _, err := mem.Stat("index.html.gz"); err == nil {
+		t.Fatalf("expected companion of small output to be removed")
+	}
+	if got := third.ChangeReport().Count(ActionRemove); got != 1 {
+		t.Fatalf("expected one removal, got %+v", third.ChangeReport().Changes)
+	}
+}
+
+func TestOutputGzipCopyAndPrune(t *testing.T) {
+	root := t.TempDir()
+	src := filepath.Join(t.TempDir(), "app.css")
+	if err := os.WriteFile(src, []byte(strings.Repeat("body{margin:0}\n", 10)), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+	out, err := NewOutput(root, OutputOptions{Gzip: &GzipOptions{MinSize: 64}})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+	if err := out.CopyFileIfChanged(src, "css/app.css"); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "css", "app.css.gz")); err != nil {
+		t.Fatalf("expected companion: %v", err)
+	}
+
+	next, err := NewOutput(root, OutputOptions{Gzip: &GzipOptions{MinSize: 64}})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+	removed, err := next.Prune()
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if want := []string{"css/app.css", "css/app.css.gz"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removed got %v want %v", removed, want)
+	}
+}
+
+func TestGzipOptionsValidation(t *testing.T) {
+	for _, opts := range []GzipOptions{
+		{MinSize: -1},
+		{Level: 12},
+		{Extensions: []string{"html"}},
+		{Extensions: []string{".gz"}},
+	} {
+		if _, err := NewOutputFS(NewMemFS(), OutputOptions{Gzip: &opts}); err == nil {
+			t.Fatalf("expected error for %+v", opts)
+		}
+	}
+}
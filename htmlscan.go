@@ -0,0 +1,203 @@
+package foundry
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+// htmlAttr is an attribute of a start tag. Name is lower-cased, Val has
+// character references decoded, Raw is the value as written without quotes,
+// and Line is the 1-based line the attribute starts on.
+type htmlAttr struct {
+	Name string
+	Val  string
+	Raw  string
+	Line int
+}
+
+// htmlTag is a start tag found by scanHTML. SelfClosing is set for tags
+// ending in "/>", which matters inside SVG and MathML.
+type htmlTag struct {
+	Name        string
+	Attrs       []htmlAttr
+	Line        int
+	SelfClosing bool
+}
+
+// attr returns the value of the named attribute.
+func (t htmlTag) attr(name string) (htmlAttr, bool) {
+	for _, a := range t.Attrs {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return htmlAttr{}, false
+}
+
+// rawTextElements hold text that is not markup and must not be scanned for
+// tags.
+var rawTextElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true, "xmp": true}
+
+// scanHTML calls fn for every start tag in doc. It is a forgiving scanner for
+// generated pages rather than a full HTML parser: comments, doctypes and the
+// contents of raw text elements such as script and style are skipped, and
+// malformed markup is passed over instead of reported.
+func scanHTML(doc []byte, fn func(htmlTag)) {
+	s := htmlScanner{doc: doc, line: 1}
+	for {
+		i := bytes.IndexByte(s.doc[s.pos:], '<')
+		if i < 0 {
+			return
+		}
+		s.advance(s.pos + i)
+		rest := s.doc[s.pos:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			s.skipPast("-->", 4)
+		case bytes.HasPrefix(rest, []byte("<!")), bytes.HasPrefix(rest, []byte("<?")), bytes.HasPrefix(rest, []byte("</")):
+			s.skipPast(">", 2)
+		case len(rest) > 1 && isASCIILetter(rest[1]):
+			tag := s.startTag()
+			fn(tag)
+			if rawTextElements[tag.Name] {
+				s.skipRawText(tag.Name)
+			}
+		default:
+			s.advance(s.pos + 1)
+		}
+	}
+}
+
+type htmlScanner struct {
+	doc  []byte
+	pos  int
+	line int
+}
+
+// advance moves to pos, counting the newlines passed over.
+func (s *htmlScanner) advance(pos int) {
+	if pos > len(s.doc) {
+		pos = len(s.doc)
+	}
+	s.line += bytes.Count(s.doc[s.pos:pos], []byte("\n"))
+	s.pos = pos
+}
+
+// skipPast moves beyond the next occurrence of end, searching from offset
+// bytes after the current position, or to the end of the document.
+func (s *htmlScanner) skipPast(end string, offset int) {
+	from := min(s.pos+offset, len(s.doc))
+	if i := bytes.Index(s.doc[from:], []byte(end)); i >= 0 {
+		s.advance(from + i + len(end))
+		return
+	}
+	s.advance(len(s.doc))
+}
+
+// skipRawText moves past the end tag of the raw text element name.
+func (s *htmlScanner) skipRawText(name string) {
+	if i := indexEndTag(s.doc[s.pos:], name); i >= 0 {
+		s.advance(s.pos + i)
+		s.skipPast(">", 2)
+		return
+	}
+	s.advance(len(s.doc))
+}
+
+// indexEndTag returns the offset of the first end tag for the lower-case
+// element name in doc, or -1. Names compare case-insensitively in place, so
+// long scripts and styles are not copied to be lowered.
+func indexEndTag(doc []byte, name string) int {
+	for i := 0; ; {
+		j := bytes.Index(doc[i:], []byte("</"))
+		if j < 0 {
+			return -1
+		}
+		i += j + 2
+		rest := doc[i:]
+		if len(rest) > len(name) && bytes.EqualFold(rest[:len(name)], []byte(name)) {
+			if c := rest[len(name)]; isHTMLSpace(c) || c == '/' || c == '>' {
+				return i - 2
+			}
+		}
+	}
+}
+
+// startTag reads the start tag at the current position.
+func (s *htmlScanner) startTag() htmlTag {
+	tag := htmlTag{Line: s.line}
+	s.advance(s.pos + 1)
+	tag.Name = strings.ToLower(s.readUntil(func(c byte) bool { return isHTMLSpace(c) || c == '/' || c == '>' }))
+	for {
+		start := s.pos
+		s.skipWhile(func(c byte) bool { return isHTMLSpace(c) || c == '/' })
+		if s.pos >= len(s.doc) {
+			return tag
+		}
+		if s.doc[s.pos] == '>' {
+			tag.SelfClosing = s.pos > start && s.doc[s.pos-1] == '/'
+			s.advance(s.pos + 1)
+			return tag
+		}
+		attr := htmlAttr{Line: s.line}
+		attr.Name = strings.ToLower(s.readUntil(func(c byte) bool { return isHTMLSpace(c) || c == '/' || c == '>' || c == '=' }))
+		if attr.Name == "" {
+			// A stray "=" or similar; skip it so the loop makes progress.
+			attr.Name = string(s.doc[s.pos])
+			s.advance(s.pos + 1)
+		}
+		s.skipWhile(isHTMLSpace)
+		if s.pos < len(s.doc) && s.doc[s.pos] == '=' {
+			s.advance(s.pos + 1)
+			s.skipWhile(isHTMLSpace)
+			attr.Raw = s.readValue()
+			attr.Val = html.UnescapeString(attr.Raw)
+		}
+		tag.Attrs = append(tag.Attrs, attr)
+	}
+}
+
+// readValue reads a quoted or unquoted attribute value.
+func (s *htmlScanner) readValue() string {
+	if s.pos >= len(s.doc) {
+		return ""
+	}
+	if q := s.doc[s.pos]; q == '"' || q == '\'' {
+		start := s.pos + 1
+		end := bytes.IndexByte(s.doc[start:], q)
+		if end < 0 {
+			s.advance(len(s.doc))
+			return string(s.doc[start:])
+		}
+		s.advance(start + end + 1)
+		return string(s.doc[start : start+end])
+	}
+	return s.readUntil(func(c byte) bool { return isHTMLSpace(c) || c == '>' })
+}
+
+func (s *htmlScanner) readUntil(stop func(byte) bool) string {
+	start := s.pos
+	end := start
+	for end < len(s.doc) && !stop(s.doc[end]) {
+		end++
+	}
+	s.advance(end)
+	return string(s.doc[start:end])
+}
+
+func (s *htmlScanner) skipWhile(match func(byte) bool) {
+	end := s.pos
+	for end < len(s.doc) && match(s.doc[end]) {
+		end++
+	}
+	s.advance(end)
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isASCIILetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
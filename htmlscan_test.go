@@ -0,0 +1,66 @@
+package foundry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScanHTML(t *testing.T) {
+	doc := `<!DOCTYPE html>
+<!-- <a href="/commented"> -->
+<A HREF='/one?a=1&amp;b=2' class=x
+   data-empty>
+<script>var s = "<a href='/in-script'>";</script>
+<img
+  src=/two.png alt="a > b" hidden/>
+<p>1 < 2</p><br/>
+<style>a{background:url("<a href=/css>")}</STYLE >
+</body>`
+	var got []htmlTag
+	scanHTML([]byte(doc), func(tag htmlTag) { got = append(got, tag) })
+
+	want := []htmlTag{
+		{Name: "a", Line: 3, Attrs: []htmlAttr{
+			{Name: "href", Val: "/one?a=1&b=2", Raw: "/one?a=1&amp;b=2", Line: 3},
+			{Name: "class", Val: "x", Raw: "x", Line: 3},
+			{Name: "data-empty", Line: 4},
+		}},
+		{Name: "script", Line: 5},
+		{Name: "img", Line: 6, Attrs: []htmlAttr{
+			{Name: "src", Val: "/two.png", Raw: "/two.png", Line: 7},
+			{Name: "alt", Val: "a > b", Raw: "a > b", Line: 7},
+			{Name: "hidden", Line: 7},
+		}, SelfClosing: true},
+		{Name: "p", Line: 8},
+		{Name: "br", Line: 8, SelfClosing: true},
+		{Name: "style", Line: 9},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestScanHTMLTruncated(t *testing.T) {
+	for _, doc := range []string{"<a href=\"/x", "<!-- open", "<script>never closed", "<a =b>", "<"} {
+		scanHTML([]byte(doc), func(htmlTag) {})
+	}
+}
+
+func TestIndexEndTag(t *testing.T) {
+	cases := []struct {
+		doc  string
+		want int
+	}{
+		{"a</script>", 1},
+		{"a</SCRIPT >", 1},
+		{"</scripts></ScRiPt/>", 10},
+		{"a < b </", -1},
+		{"</script", -1},
+		{"no end tag", -1},
+	}
+	for _, c := range cases {
+		if got := indexEndTag([]byte(c.doc), "script"); got != c.want {
+			t.Errorf("indexEndTag(%q) got %d want %d", c.doc, got, c.want)
+		}
+	}
+}
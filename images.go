@@ -0,0 +1,519 @@
+package foundry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	colorpalette "image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const defaultJPEGQuality = 82
+
+// imageCacheVersion is part of every cache key; bump it when the resampler or
+// encoders change output so stale cache entries are not reused.
+const imageCacheVersion = 2
+
+// ImageOptions configures ProcessImages.
+type ImageOptions struct {
+	// Widths lists the widths in pixels of the variants to generate. Widths
+	// at or above an image's own width produce a single variant at its
+	// natural size, so images are never enlarged. When empty, each image is
+	// only re-encoded at its natural size.
+	Widths []int
+	// Quality is the JPEG quality from 1 to 100. It defaults to 82.
+	Quality int
+	// CacheDir, when set, holds encoded variants keyed by the source content
+	// and the parameters above, so rebuilds skip decoding and resampling.
+	CacheDir string
+	// Workers bounds how many images are processed at once. It defaults to
+	// runtime.NumCPU().
+	Workers int
+}
+
+// ImageJob names a source image and the output path it is published under.
+type ImageJob struct {
+	// Src is the path of the source file on disk.
+	Src string
+	// Logical is the slash-separated output path of the image, such as
+	// "img/photo.jpg". Variants are written next to it as "img/photo-480w.jpg".
+	Logical string
+}
+
+// ImageVariant is one generated size of an image.
+type ImageVariant struct {
+	// Path is the output path of the variant.
+	Path   string `json:"path"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// ProcessedImage describes the variants generated for one ImageJob.
+type ProcessedImage struct {
+	Logical string `json:"logical"`
+	// Width and Height are the dimensions of the source after orientation.
+	Width    int            `json:"width"`
+	Height   int            `json:"height"`
+	Variants []ImageVariant `json:"variants"`
+}
+
+// Srcset returns a srcset attribute value listing every variant, with
+// urlPrefix, such as "/", prepended to each path.
+func (p ProcessedImage) Srcset(urlPrefix string) string {
+	parts := make([]string, len(p.Variants))
+	for i, v := range p.Variants {
+		parts[i] = fmt.Sprintf("%s%s %dw", urlPrefix, v.Path, v.Width)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ProcessImages decodes each JPEG, PNG or GIF source, applies its EXIF
+// orientation, and writes a resized variant per configured width through
+// out, in the source's format. Re-encoding drops all metadata; GIFs keep
+// their palette and transparency, but animated GIFs keep only their first
+// frame. Results are in job order. Failures for individual images are
+// collected and returned together.
+func ProcessImages(out *Output, jobs []ImageJob, opts ImageOptions) ([]ProcessedImage, error) {
+	if out == nil {
+		return nil, errors.New("foundry: images output is nil")
+	}
+	if opts.Quality == 0 {
+		opts.Quality = defaultJPEGQuality
+	}
+	if opts.Quality < 1 || opts.Quality > 100 {
+		return nil, fmt.Errorf("foundry: jpeg quality must be between 1 and 100 (got %d)", opts.Quality)
+	}
+	for _, w := range opts.Widths {
+		if w <= 0 {
+			return nil, fmt.Errorf("foundry: image widths must be positive (got %d)", w)
+		}
+	}
+	if opts.Workers < 0 {
+		return nil, fmt.Errorf("foundry: image workers must not be negative (got %d)", opts.Workers)
+	}
+	if opts.Workers == 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.CacheDir != "" {
+		if err := EnsureDir(opts.CacheDir); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]ProcessedImage, len(jobs))
+	indexes := make([]int, len(jobs))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	var mu sync.Mutex
+	var errs []error
+	err := ForEachParallel(indexes, opts.Workers, func(i int) {
+		result, err := processImage(out, jobs[i], opts)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		results[i] = result
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return results, nil
+}
+
+func processImage(out *Output, job ImageJob, opts ImageOptions) (ProcessedImage, error) {
+	data, err := os.ReadFile(job.Src)
+	if err != nil {
+		return ProcessedImage{}, fmt.Errorf("foundry: read image: %w", err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ProcessedImage{}, fmt.Errorf("foundry: decode image %s: %w", job.Src, err)
+	}
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	width, height := cfg.Width, cfg.Height
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	result := ProcessedImage{Logical: job.Logical, Width: width, Height: height}
+
+	ext := path.Ext(job.Logical)
+	stem := strings.TrimSuffix(job.Logical, ext)
+	sourceHash := hashBytes(data)
+	var decoded *image.RGBA
+	var palette color.Palette
+	for _, w := range variantWidths(opts.Widths, width) {
+		h := max(1, int(math.Round(float64(height)*float64(w)/float64(width))))
+		variant := ImageVariant{Path: fmt.Sprintf("%s-%dw%s", stem, w, ext), Width: w, Height: h}
+
+		cachePath := ""
+		if opts.CacheDir != "" {
+			key := fmt.Sprintf("%s-%dx%d-q%d-v%d.%s", sourceHash, w, h, opts.Quality, imageCacheVersion, format)
+			cachePath = filepath.Join(opts.CacheDir, key)
+		}
+		encoded, err := os.ReadFile(cachePath)
+		if cachePath == "" || err != nil {
+			if decoded == nil {
+				img, _, err := image.Decode(bytes.NewReader(data))
+				if err != nil {
+					return ProcessedImage{}, fmt.Errorf("foundry: decode image %s: %w", job.Src, err)
+				}
+				if p, ok := img.(*image.Paletted); ok {
+					palette = p.Palette
+				}
+				decoded = orientImage(toRGBA(img), orientation)
+			}
+			if encoded, err = encodeImage(resizeImage(decoded, w, h), format, opts.Quality, palette); err != nil {
+				return ProcessedImage{}, fmt.Errorf("foundry: encode image %s: %w", variant.Path, err)
+			}
+			if cachePath != "" {
+				if err := WriteIfChanged(cachePath, encoded); err != nil {
+					return ProcessedImage{}, err
+				}
+			}
+		}
+		if err := out.WriteIfChanged(variant.Path, encoded); err != nil {
+			return ProcessedImage{}, err
+		}
+		result.Variants = append(result.Variants, variant)
+	}
+	return result, nil
+}
+
+// variantWidths returns the sorted, distinct widths to generate for an image
+// of the given natural width.
+func variantWidths(widths []int, natural int) []int {
+	out := []int{natural}
+	if len(widths) > 0 {
+		out = out[:0]
+		for _, w := range widths {
+			out = append(out, min(w, natural))
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// encodeImage encodes img in format. GIFs are mapped back onto palette, the
+// source's palette, so they keep their colors and transparency.
+func encodeImage(img *image.RGBA, format string, quality int, palette color.Palette) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		paletted := toPaletted(img, palette)
+		err = gif.Encode(&buf, paletted, &gif.Options{NumColors: len(paletted.Palette), Drawer: draw.Src})
+	default:
+		err = fmt.Errorf("unsupported format %q", format)
+	}
+	return buf.Bytes(), err
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst
+}
+
+// toPaletted maps img onto palette without dithering. Pixels that are mostly
+// transparent use the palette's transparent entry, which is added when the
+// palette has none and room for one.
+func toPaletted(img *image.RGBA, palette color.Palette) *image.Paletted {
+	if len(palette) == 0 {
+		palette = colorpalette.Plan9
+	}
+	palette = slices.Clone(palette)
+	transparent := -1
+	for i, c := range palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			transparent = i
+			break
+		}
+	}
+	if transparent < 0 && len(palette) < 256 {
+		transparent = len(palette)
+		palette = append(palette, color.RGBA{})
+	}
+	dst := image.NewPaletted(img.Rect, palette)
+	indexes := make(map[color.RGBA]uint8)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			if c.A < 128 && transparent >= 0 {
+				dst.SetColorIndex(x, y, uint8(transparent))
+				continue
+			}
+			idx, ok := indexes[c]
+			if !ok {
+				opaque := color.NRGBAModel.Convert(c).(color.NRGBA)
+				opaque.A = 255
+				idx = uint8(opaqueIndex(palette, opaque, transparent))
+				indexes[c] = idx
+			}
+			dst.SetColorIndex(x, y, idx)
+		}
+	}
+	return dst
+}
+
+// opaqueIndex returns the palette entry closest to c, skipping the
+// transparent entry at skip.
+func opaqueIndex(palette color.Palette, c color.NRGBA, skip int) int {
+	best, bestDist := 0, uint32(math.MaxUint32)
+	cr, cg, cb, _ := c.RGBA()
+	for i, p := range palette {
+		if i == skip {
+			continue
+		}
+		pr, pg, pb, _ := p.RGBA()
+		dist := sqDiff(cr, pr) + sqDiff(cg, pg) + sqDiff(cb, pb)
+		if dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+// sqDiff returns the squared difference of two 16-bit color channels scaled
+// down so that three of them fit in a uint32.
+func sqDiff(a uint32, b uint32) uint32 {
+	d := int64(a) - int64(b)
+	return uint32(d * d >> 2)
+}
+
+// orientImage returns src transformed so that EXIF orientation o displays
+// upright.
+func orientImage(src *image.RGBA, o int) *image.RGBA {
+	if o < 2 || o > 8 {
+		return src
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		for x := range dw {
+			var sx, sy int
+			switch o {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
+
+// resizeImage resamples src to w by h with a Catmull-Rom filter, one axis at
+// a time. Working on premultiplied pixels keeps transparent edges from
+// bleeding color.
+func resizeImage(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if w == sw && h == sh {
+		return src
+	}
+	tmp := make([]float32, w*sh*4)
+	for y := range sh {
+		for x, c := range resampleWeights(w, sw) {
+			var px [4]float32
+			for k, wt := range c.weights {
+				i := src.PixOffset(c.start+k, y)
+				for ch := range px {
+					px[ch] += float32(src.Pix[i+ch]) * wt
+				}
+			}
+			copy(tmp[(y*w+x)*4:], px[:])
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, c := range resampleWeights(h, sh) {
+		for x := range w {
+			var px [4]float32
+			for k, wt := range c.weights {
+				i := ((c.start+k)*w + x) * 4
+				for ch := range px {
+					px[ch] += tmp[i+ch] * wt
+				}
+			}
+			i := dst.PixOffset(x, y)
+			alpha := clampChannel(px[3], 255)
+			dst.Pix[i+3] = alpha
+			for ch := range 3 {
+				dst.Pix[i+ch] = clampChannel(px[ch], alpha)
+			}
+		}
+	}
+	return dst
+}
+
+// resampleContrib lists the source pixels, from start, that make up one
+// destination pixel and their normalized weights.
+type resampleContrib struct {
+	start   int
+	weights []float32
+}
+
+func resampleWeights(dst, src int) []resampleContrib {
+	scale := float64(src) / float64(dst)
+	filterScale := max(scale, 1)
+	radius := 2 * filterScale
+	contribs := make([]resampleContrib, dst)
+	for i := range contribs {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := max(0, int(math.Ceil(center-radius)))
+		end := min(src-1, int(math.Floor(center+radius)))
+		weights := make([]float32, 0, end-start+1)
+		var sum float64
+		for j := start; j <= end; j++ {
+			wt := catmullRom((float64(j) - center) / filterScale)
+			weights = append(weights, float32(wt))
+			sum += wt
+		}
+		if sum != 0 {
+			for k := range weights {
+				weights[k] /= float32(sum)
+			}
+		}
+		contribs[i] = resampleContrib{start: start, weights: weights}
+	}
+	return contribs
+}
+
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+func clampChannel(v float32, limit uint8) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= float32(limit):
+		return limit
+	}
+	return uint8(v + 0.5)
+}
+
+// jpegOrientation returns the EXIF orientation (1 to 8) stored in a JPEG's
+// APP1 segment, or 1 when there is none or it cannot be read.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			i++
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// Metadata segments all precede the image data.
+			return 1
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD8:
+			i += 2
+			continue
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation reads the Orientation tag from the first IFD of a TIFF
+// structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	for k := range int(order.Uint16(tiff[ifd:])) {
+		entry := ifd + 2 + 12*k
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		// Orientation is tag 0x0112, a single SHORT.
+		if order.Uint16(tiff[entry:]) != 0x0112 {
+			continue
+		}
+		if order.Uint16(tiff[entry+2:]) != 3 {
+			return 1
+		}
+		if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+			return v
+		}
+		return 1
+	}
+	return 1
+}
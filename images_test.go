@@ -0,0 +1,262 @@
+package foundry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// halves returns a w by h image whose left half is red and right half blue.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// withOrientation inserts an EXIF APP1 segment carrying orientation after
+// the SOI marker of a JPEG.
+func withOrientation(t *testing.T, data []byte, orientation uint16) []byte {
+	t.Helper()
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	for _, v := range []any{uint16(42), uint32(8), uint16(1), uint16(0x0112), uint16(3), uint32(1), orientation, uint16(0), uint32(0)} {
+		if err := binary.Write(&tiff, binary.BigEndian, v); err != nil {
+			t.Fatalf("encode exif: %v", err)
+		}
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestProcessImages(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, halves(400, 200)); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	photo := filepath.Join(dir, "photo.png")
+	if err := os.WriteFile(photo, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	mem := NewMemFS()
+	out, err := NewOutputFS(mem, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	results, err := ProcessImages(out, []ImageJob{{Src: photo, Logical: "img/photo.png"}}, ImageOptions{Widths: []int{800, 100, 200, 200}})
+	if err != nil {
+		t.Fatalf("ProcessImages: %v", err)
+	}
+	want := []ImageVariant{
+		{Path: "img/photo-100w.png", Width: 100, Height: 50},
+		{Path: "img/photo-200w.png", Width: 200, Height: 100},
+		{Path: "img/photo-400w.png", Width: 400, Height: 200},
+	}
+	got := results[0]
+	if got.Width != 400 || got.Height != 200 || len(got.Variants) != len(want) {
+		t.Fatalf("unexpected result %+v", got)
+	}
+	for i, v := range want {
+		if got.Variants[i] != v {
+			t.Fatalf("variant %d got %+v want %+v", i, got.Variants[i], v)
+		}
+		img, err := png.Decode(strings.NewReader(readMem(t, mem, v.Path)))
+		if err != nil {
+			t.Fatalf("decode %s: %v", v.Path, err)
+		}
+		if b := img.Bounds(); b.Dx() != v.Width || b.Dy() != v.Height {
+			t.Fatalf("%s has size %v", v.Path, b)
+		}
+		if r, _, b, _ := img.At(2, 2).RGBA(); r>>8 != 255 || b != 0 {
+			t.Fatalf("%s left edge is not red", v.Path)
+		}
+	}
+	if srcset := got.Srcset("/"); srcset != "/img/photo-100w.png 100w, /img/photo-200w.png 200w, /img/photo-400w.png 400w" {
+		t.Fatalf("srcset got %q", srcset)
+	}
+}
+
+func TestProcessImagesOrientationAndCache(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, halves(64, 32), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	// Orientation 6 means the camera was rotated; the image displays 90
+	// degrees clockwise, so the red half ends up on top.
+	photo := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(photo, withOrientation(t, buf.Bytes(), 6), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	cache := filepath.Join(dir, "cache")
+	opts := ImageOptions{Widths: []int{16}, Quality: 90, CacheDir: cache, Workers: 2}
+	jobs := []ImageJob{{Src: photo, Logical: "photo.jpg"}}
+
+	mem := NewMemFS()
+	out, err := NewOutputFS(mem, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	results, err := ProcessImages(out, jobs, opts)
+	if err != nil {
+		t.Fatalf("ProcessImages: %v", err)
+	}
+	if v := results[0].Variants[0]; results[0].Width != 32 || results[0].Height != 64 || v.Width != 16 || v.Height != 32 {
+		t.Fatalf("unexpected result %+v", results[0])
+	}
+	data := readMem(t, mem, "photo-16w.jpg")
+	if jpegOrientation([]byte(data)) != 1 || strings.Contains(data, "Exif") {
+		t.Fatalf("metadata was not stripped")
+	}
+	img, err := jpeg.Decode(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if r, _, b, _ := img.At(8, 4).RGBA(); r>>8 < 200 || b>>8 > 55 {
+		t.Fatalf("top is not red: %v", img.At(8, 4))
+	}
+	if r, _, b, _ := img.At(8, 28).RGBA(); b>>8 < 200 || r>>8 > 55 {
+		t.Fatalf("bottom is not blue: %v", img.At(8, 28))
+	}
+
+	// A second build is served from the cache rather than re-encoded.
+	entries, err := os.ReadDir(cache)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one cache entry, got %v %v", entries, err)
+	}
+	if err := os.WriteFile(filepath.Join(cache, entries[0].Name()), []byte("cached"), 0o644); err != nil {
+		t.Fatalf("write cache: %v", err)
+	}
+	out, err = NewOutputFS(mem, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	if _, err := ProcessImages(out, jobs, opts); err != nil {
+		t.Fatalf("ProcessImages: %v", err)
+	}
+	if got := readMem(t, mem, "photo-16w.jpg"); got != "cached" {
+		t.Fatalf("expected cached variant, got %d bytes", len(got))
+	}
+
+	// Changing a parameter misses the cache.
+	opts.Quality = 50
+	out, err = NewOutputFS(mem, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	if _, err := ProcessImages(out, jobs, opts); err != nil {
+		t.Fatalf("ProcessImages: %v", err)
+	}
+	if got := readMem(t, mem, "photo-16w.jpg"); got == "cached" {
+		t.Fatal("quality change reused the cache")
+	}
+}
+
+func TestProcessImagesGIFTransparency(t *testing.T) {
+	dir := t.TempDir()
+	red := color.RGBA{R: 255, A: 255}
+	src := image.NewPaletted(image.Rect(0, 0, 16, 16), color.Palette{color.RGBA{}, red})
+	for y := range 16 {
+		for x := 8; x < 16; x++ {
+			src.SetColorIndex(x, y, 1)
+		}
+	}
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, src, nil); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+	icon := filepath.Join(dir, "icon.gif")
+	if err := os.WriteFile(icon, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	mem := NewMemFS()
+	out, err := NewOutputFS(mem, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	if _, err := ProcessImages(out, []ImageJob{{Src: icon, Logical: "icon.gif"}}, ImageOptions{Widths: []int{8, 16}}); err != nil {
+		t.Fatalf("ProcessImages: %v", err)
+	}
+	for _, v := range []struct {
+		path  string
+		width int
+	}{{"icon-8w.gif", 8}, {"icon-16w.gif", 16}} {
+		data := readMem(t, mem, v.path)
+		if len(data) > 2*buf.Len() {
+			t.Errorf("%s grew from %d to %d bytes", v.path, buf.Len(), len(data))
+		}
+		img, err := gif.Decode(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode %s: %v", v.path, err)
+		}
+		if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+			t.Errorf("%s: transparent pixel has alpha %d", v.path, a)
+		}
+		if got := color.RGBAModel.Convert(img.At(v.width-1, 0)); got != red {
+			t.Errorf("%s: opaque pixel is %v", v.path, got)
+		}
+	}
+}
+
+func TestProcessImagesErrors(t *testing.T) {
+	dir := t.TempDir()
+	bogus := filepath.Join(dir, "bogus.png")
+	if err := os.WriteFile(bogus, []byte("not an image"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	out, err := NewOutputFS(NewMemFS(), OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	jobs := []ImageJob{{Src: bogus, Logical: "bogus.png"}, {Src: filepath.Join(dir, "missing.png"), Logical: "missing.png"}}
+	_, err = ProcessImages(out, jobs, ImageOptions{})
+	if err == nil || !strings.Contains(err.Error(), "decode image") || !strings.Contains(err.Error(), "read image") {
+		t.Fatalf("expected both failures, got %v", err)
+	}
+	if _, err := ProcessImages(out, nil, ImageOptions{Quality: 101}); err == nil {
+		t.Fatal("expected quality error")
+	}
+	if _, err := ProcessImages(out, nil, ImageOptions{Widths: []int{0}}); err == nil {
+		t.Fatal("expected width error")
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// A 2x1 image with pixels a, b.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Pix[0], src.Pix[4] = 'a', 'b'
+	cases := map[int]string{1: "ab", 2: "ba", 3: "ba", 4: "ab", 5: "ab", 6: "ab", 7: "ba", 8: "ba"}
+	for o, want := range cases {
+		dst := orientImage(src, o)
+		got := string([]byte{dst.Pix[0], dst.Pix[len(dst.Pix)-4]})
+		if got != want {
+			t.Errorf("orientation %d got %q want %q", o, got, want)
+		}
+		if tall := dst.Rect.Dy() == 2; tall != (o >= 5) {
+			t.Errorf("orientation %d has bounds %v", o, dst.Rect)
+		}
+	}
+}
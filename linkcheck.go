@@ -0,0 +1,346 @@
+package foundry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// linkAttrs lists, per attribute, the elements whose attribute holds a URL
+// the checker follows.
+var linkAttrs = map[string][]string{
+	"href":   {"a", "area", "link"},
+	"src":    {"audio", "embed", "iframe", "img", "input", "script", "source", "track", "video"},
+	"srcset": {"img", "source"},
+	"poster": {"video"},
+}
+
+// LinkCheckOptions configures CheckLinks.
+type LinkCheckOptions struct {
+	// Policy maps the site's URLs to files. It defaults to pretty URLs at
+	// the site root; set it to the policy the build used when the site is
+	// published under a base path or links use absolute URLs.
+	Policy *URLPolicy
+	// Ignore lists URL prefixes, such as "/api/" or "/downloads/", that are
+	// served by something other than the built files and are not checked.
+	Ignore []string
+	// Workers bounds how many pages are parsed at once. It defaults to
+	// runtime.NumCPU().
+	Workers int
+}
+
+// BrokenLink is an internal link that does not resolve.
+type BrokenLink struct {
+	// Source is the slash-separated path of the page containing the link.
+	Source string `json:"source"`
+	Line   int    `json:"line"`
+	// Attr is the attribute holding the link, such as "href" or "src".
+	Attr   string `json:"attr"`
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
+func (b BrokenLink) String() string {
+	return fmt.Sprintf("%s:%d: %s %q: %s", b.Source, b.Line, b.Attr, b.URL, b.Reason)
+}
+
+// LinkReport is the result of CheckLinks.
+type LinkReport struct {
+	// Pages is the number of HTML files scanned and Links the number of
+	// internal links checked.
+	Pages  int          `json:"pages"`
+	Links  int          `json:"links"`
+	Broken []BrokenLink `json:"broken"`
+}
+
+// OK reports whether no broken links were found.
+func (r *LinkReport) OK() bool {
+	return len(r.Broken) == 0
+}
+
+// Err returns an error listing every broken link, or nil, so the report can
+// gate a build or CI job:
+//
+//	foundry.WithStep("check links", func() error {
+//		report, err := foundry.CheckLinks(os.DirFS("dist"), foundry.LinkCheckOptions{})
+//		if err != nil {
+//			return err
+//		}
+//		return report.Err()
+//	})
+func (r *LinkReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	lines := make([]string, len(r.Broken))
+	for i, b := range r.Broken {
+		lines[i] = b.String()
+	}
+	return fmt.Errorf("foundry: %d broken links:\n%s", len(r.Broken), strings.Join(lines, "\n"))
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *LinkReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("foundry: encode link report: %w", err)
+	}
+	return nil
+}
+
+// pageLinks is what CheckLinks extracts from one HTML file.
+type pageLinks struct {
+	links []pageLink
+	ids   map[string]bool
+}
+
+type pageLink struct {
+	attr string
+	url  string
+	line int
+}
+
+// CheckLinks scans every HTML file in fsys, typically os.DirFS("dist") after
+// a build, and checks that each internal href, src, srcset and poster URL
+// names a file in fsys and that each fragment names an id (or a legacy
+// <a name>) on the target page. Relative, root-relative and same-site
+// absolute URLs are checked; other schemes and hosts are not. The returned
+// error covers failures to read fsys; broken links are in the report.
+func CheckLinks(fsys fs.FS, opts LinkCheckOptions) (*LinkReport, error) {
+	if fsys == nil {
+		return nil, errors.New("foundry: link check filesystem is nil")
+	}
+	policy := opts.Policy
+	if policy == nil {
+		var err error
+		if policy, err = NewURLPolicy(URLOptions{}); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Workers < 0 {
+		return nil, fmt.Errorf("foundry: link check workers must not be negative (got %d)", opts.Workers)
+	}
+	if opts.Workers == 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+
+	files, err := treeFiles(fsys)
+	if err != nil {
+		return nil, err
+	}
+	var pagePaths []string
+	for name := range files {
+		if isHTML(name) {
+			pagePaths = append(pagePaths, name)
+		}
+	}
+	slices.Sort(pagePaths)
+
+	var mu sync.Mutex
+	var errs []error
+	pages := make(map[string]*pageLinks, len(pagePaths))
+	err = ForEachParallel(pagePaths, opts.Workers, func(name string) {
+		doc, err := fs.ReadFile(fsys, name)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("foundry: read %s: %w", name, err))
+			return
+		}
+		pages[name] = extractLinks(doc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	c := linkChecker{policy: policy, ignore: opts.Ignore, files: files, pages: pages}
+	report := &LinkReport{Pages: len(pagePaths), Broken: []BrokenLink{}}
+	for _, name := range pagePaths {
+		for _, link := range pages[name].links {
+			reason, checked := c.check(name, link.url)
+			if !checked {
+				continue
+			}
+			report.Links++
+			if reason != "" {
+				report.Broken = append(report.Broken, BrokenLink{Source: name, Line: link.line, Attr: link.attr, URL: link.url, Reason: reason})
+			}
+		}
+	}
+	return report, nil
+}
+
+// extractLinks collects the followed URLs and the fragment targets of doc.
+func extractLinks(doc []byte) *pageLinks {
+	p := &pageLinks{ids: make(map[string]bool)}
+	scanHTML(doc, func(tag htmlTag) {
+		for _, a := range tag.Attrs {
+			switch {
+			case a.Name == "id" && a.Val != "":
+				p.ids[a.Val] = true
+			case a.Name == "name" && tag.Name == "a" && a.Val != "":
+				p.ids[a.Val] = true
+			case slices.Contains(linkAttrs[a.Name], tag.Name):
+				if tag.Name == "link" && isResourceHint(tag) {
+					continue
+				}
+				if a.Name == "srcset" {
+					for _, u := range srcsetURLs(a.Val) {
+						p.links = append(p.links, pageLink{attr: a.Name, url: u, line: a.Line})
+					}
+					continue
+				}
+				if u := strings.TrimSpace(a.Val); u != "" {
+					p.links = append(p.links, pageLink{attr: a.Name, url: u, line: a.Line})
+				}
+			}
+		}
+	})
+	return p
+}
+
+// isResourceHint reports whether a link element points at another origin
+// rather than a resource, as preconnect and dns-prefetch hints do.
+func isResourceHint(tag htmlTag) bool {
+	rel, _ := tag.attr("rel")
+	for _, r := range strings.Fields(strings.ToLower(rel.Val)) {
+		if r == "preconnect" || r == "dns-prefetch" {
+			return true
+		}
+	}
+	return false
+}
+
+// srcsetURLs returns the URLs of the image candidates in a srcset value,
+// following the HTML parsing algorithm: a URL is a run of non-whitespace, so
+// commas inside it (as in data: URLs) do not split candidates, and a comma
+// ends a candidate only after its descriptors.
+func srcsetURLs(srcset string) []string {
+	var urls []string
+	for i := 0; i < len(srcset); {
+		for i < len(srcset) && (isHTMLSpace(srcset[i]) || srcset[i] == ',') {
+			i++
+		}
+		start := i
+		for i < len(srcset) && !isHTMLSpace(srcset[i]) {
+			i++
+		}
+		if start == i {
+			break
+		}
+		u := srcset[start:i]
+		if trimmed := strings.TrimRight(u, ","); trimmed != u {
+			// A URL ending in commas has no descriptors.
+			if trimmed != "" {
+				urls = append(urls, trimmed)
+			}
+			continue
+		}
+		urls = append(urls, u)
+		// Skip the descriptors, up to a comma outside parentheses.
+		depth := 0
+		for ; i < len(srcset); i++ {
+			switch srcset[i] {
+			case '(':
+				depth++
+			case ')':
+				depth = max(0, depth-1)
+			}
+			if srcset[i] == ',' && depth == 0 {
+				break
+			}
+		}
+	}
+	return urls
+}
+
+type linkChecker struct {
+	policy *URLPolicy
+	ignore []string
+	files  map[string]fs.FileInfo
+	pages  map[string]*pageLinks
+}
+
+// check resolves raw, found on page source, and returns why it is broken, or
+// "" when it resolves. checked is false for links the checker does not
+// follow, such as external URLs.
+func (c *linkChecker) check(source string, raw string) (reason string, checked bool) {
+	ref, err := url.Parse(raw)
+	if err != nil {
+		return "malformed URL", true
+	}
+	if ref.Scheme != "" && ref.Scheme != "http" && ref.Scheme != "https" {
+		return "", false
+	}
+	if ref.Host != "" && !c.policy.internal(ref) {
+		return "", false
+	}
+	pageURL, err := c.policy.URLForOutput(source)
+	if err != nil {
+		return "", false
+	}
+	resolved := (&url.URL{Path: pageURL}).ResolveReference(ref)
+	for _, prefix := range c.ignore {
+		if strings.HasPrefix(resolved.Path, prefix) {
+			return "", false
+		}
+	}
+
+	target := source
+	if ref.Path != "" || ref.Host != "" {
+		var ok bool
+		if target, ok = c.resolveFile(resolved.Path); !ok {
+			if _, err := c.policy.OutputForURL(resolved.Path); err != nil {
+				return "outside the site", true
+			}
+			return "no such file", true
+		}
+	}
+	if ref.Fragment == "" || ref.Fragment == "top" {
+		return "", true
+	}
+	page, ok := c.pages[target]
+	if !ok {
+		return "", true
+	}
+	if !page.ids[ref.Fragment] {
+		return fmt.Sprintf("no element with id %q in %s", ref.Fragment, target), true
+	}
+	return "", true
+}
+
+// resolveFile returns the file serving urlPath, trying the exact file before
+// the policy's page mapping so that extensionless files resolve.
+func (c *linkChecker) resolveFile(urlPath string) (string, bool) {
+	name, err := c.policy.OutputForURL(urlPath)
+	if err != nil {
+		return "", false
+	}
+	if exact := strings.TrimSuffix(name, "/index.html"); exact != name && !strings.HasSuffix(urlPath, "/") {
+		if _, ok := c.files[exact]; ok {
+			return exact, true
+		}
+	}
+	if _, ok := c.files[name]; ok {
+		return name, true
+	}
+	// FileURLs sites may link to pages without their extension.
+	if stem, ok := strings.CutSuffix(name, "/index.html"); ok {
+		if _, ok := c.files[stem+".html"]; ok && path.Ext(stem) == "" {
+			return stem + ".html", true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,130 @@
+package foundry
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCheckLinks(t *testing.T) {
+	post, err := MarkdownToHTML([]byte("# Getting Started\n\nSee [install](#install) and [home](../../../).\n\n## Install\n"))
+	if err != nil {
+		t.Fatalf("MarkdownToHTML: %v", err)
+	}
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte(`<html><head>
+<link rel="stylesheet" href="/css/site.css">
+<link rel="preconnect" href="/nowhere">
+</head><body>
+<a href="/en/blog/post/#getting-started">post</a>
+<a href="/en/blog/post/#missing">bad anchor</a>
+<a href="en/about/">about</a>
+<a href="/en/gone/">gone</a>
+<a href="https://other.example/x">external</a>
+<a href="mailto:me@example.com">mail</a>
+<a href="https://example.com/LICENSE">license</a>
+<a href="#top">top</a>
+<a href="/api/users">api</a>
+<img src="/img/a.png" srcset="/img/a.png 1x, /img/a@2x.png 2x">
+<a href="/css/site.css#x">css fragment</a>
+</body></html>`)},
+		"en/blog/post/index.html": {Data: post},
+		"en/about/index.html":     {Data: []byte(`<a name="legacy"></a><a href="../blog/post/#install">x</a><a href="#legacy">y</a><a href="../../index.html#nope">z</a>`)},
+		"css/site.css":            {Data: []byte("body{}")},
+		"img/a.png":               {Data: []byte("png")},
+		"LICENSE":                 {Data: []byte("MIT")},
+	}
+	policy, err := NewURLPolicy(URLOptions{BaseURL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("NewURLPolicy: %v", err)
+	}
+	report, err := CheckLinks(fsys, LinkCheckOptions{Policy: policy, Ignore: []string{"/api/"}, Workers: 2})
+	if err != nil {
+		t.Fatalf("CheckLinks: %v", err)
+	}
+
+	want := []BrokenLink{
+		{Source: "en/about/index.html", Line: 1, Attr: "href", URL: "../../index.html#nope", Reason: `no element with id "nope" in index.html`},
+		{Source: "index.html", Line: 6, Attr: "href", URL: "/en/blog/post/#missing", Reason: `no element with id "missing" in en/blog/post/index.html`},
+		{Source: "index.html", Line: 8, Attr: "href", URL: "/en/gone/", Reason: "no such file"},
+		{Source: "index.html", Line: 14, Attr: "srcset", URL: "/img/a@2x.png", Reason: "no such file"},
+	}
+	if len(report.Broken) != len(want) {
+		t.Fatalf("broken links:\n%v", report.Err())
+	}
+	for i := range want {
+		if report.Broken[i] != want[i] {
+			t.Errorf("broken[%d] = %+v want %+v", i, report.Broken[i], want[i])
+		}
+	}
+	if report.Pages != 3 || report.Links != 16 {
+		t.Errorf("pages %d links %d", report.Pages, report.Links)
+	}
+	if err := report.Err(); err == nil || !strings.Contains(err.Error(), "index.html:8: href \"/en/gone/\": no such file") {
+		t.Errorf("Err() = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var decoded LinkReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Broken) != 4 {
+		t.Fatalf("decode report: %v %+v", err, decoded)
+	}
+}
+
+func TestCheckLinksBasePath(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte(`<a href="/docs/guide.html">ok</a><a href="/blog/">outside</a><a href="guide">extensionless</a>`)},
+		"guide.html": {Data: []byte(`guide`)},
+	}
+	policy, err := NewURLPolicy(URLOptions{Style: FileURLs, BaseURL: "/docs/"})
+	if err != nil {
+		t.Fatalf("NewURLPolicy: %v", err)
+	}
+	report, err := CheckLinks(fsys, LinkCheckOptions{Policy: policy})
+	if err != nil {
+		t.Fatalf("CheckLinks: %v", err)
+	}
+	if len(report.Broken) != 1 || report.Broken[0].URL != "/blog/" || report.Broken[0].Reason != "outside the site" {
+		t.Fatalf("broken = %+v", report.Broken)
+	}
+	if report.OK() {
+		t.Fatalf("expected report to fail")
+	}
+}
+
+func TestSrcsetURLs(t *testing.T) {
+	tests := []struct {
+		srcset string
+		want   []string
+	}{
+		{"/a.png 1x, /a@2x.png 2x", []string{"/a.png", "/a@2x.png"}},
+		{"data:image/png;base64,iVBORw0KGgo= 1x, /a.png 2x", []string{"data:image/png;base64,iVBORw0KGgo=", "/a.png"}},
+		{"/a,b.png 480w,/c.png 800w", []string{"/a,b.png", "/c.png"}},
+		{"/a.png,/b.png", []string{"/a.png,/b.png"}},
+		{" /a.png ( 1x, 2x ) , /b.png", []string{"/a.png", "/b.png"}},
+		{" , ", nil},
+	}
+	for _, tt := range tests {
+		if got := srcsetURLs(tt.srcset); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("srcsetURLs(%q) = %q, want %q", tt.srcset, got, tt.want)
+		}
+	}
+
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte(`<img srcset="data:image/png;base64,iVBORw0KGgo= 1x, /a.png 2x">`)},
+		"a.png":      {Data: []byte("png")},
+	}
+	report, err := CheckLinks(fsys, LinkCheckOptions{})
+	if err != nil {
+		t.Fatalf("CheckLinks: %v", err)
+	}
+	if !report.OK() || report.Links != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,166 @@
+package foundry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const manifestVersion = 1
+
+// ManifestEntry records what an output file looked like when it was last
+// written or confirmed unchanged.
+type ManifestEntry struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Manifest caches the content hash, size and modification time of output
+// files across builds. When an Output has a Manifest, writes whose content
+// hash matches an entry skip reading the existing file as long as its size and
+// modification time still match; otherwise they fall back to comparing bytes.
+// A Manifest is safe for concurrent use.
+type Manifest struct {
+	mu      sync.RWMutex
+	entries map[string]ManifestEntry
+}
+
+type manifestFile struct {
+	Version int                      `json:"version"`
+	Files   map[string]ManifestEntry `json:"files"`
+}
+
+// NewManifest returns an empty manifest.
+func NewManifest() *Manifest {
+	return &Manifest{entries: make(map[string]ManifestEntry)}
+}
+
+// LoadManifest reads a manifest previously written by Save. A missing file
+// yields an empty manifest so that the first build starts from scratch.
+func LoadManifest(path string) (*Manifest, error) {
+	if path == "" {
+		return nil, errors.New("foundry: manifest path is empty")
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewManifest(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("foundry: read manifest: %w", err)
+	}
+
+	var file manifestFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("foundry: parse manifest: %w", err)
+	}
+	if file.Version != manifestVersion {
+		// An unknown layout is only a cache miss; start over rather than fail.
+		return NewManifest(), nil
+	}
+	m := NewManifest()
+	for key, entry := range file.Files {
+		m.entries[key] = entry
+	}
+	return m, nil
+}
+
+// Save writes the manifest to path atomically. Unchanged manifests are not
+// rewritten.
+func (m *Manifest) Save(path string) error {
+	if path == "" {
+		return errors.New("foundry: manifest path is empty")
+	}
+	m.mu.RLock()
+	data, err := json.MarshalIndent(manifestFile{Version: manifestVersion, Files: m.entries}, "", "  ")
+	m.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("foundry: encode manifest: %w", err)
+	}
+	return WriteIfChanged(path, append(data, '\n'))
+}
+
+// Get returns the entry recorded for the slash-separated path key.
+func (m *Manifest) Get(key string) (ManifestEntry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.entries[key]
+	return entry, ok
+}
+
+// Set records entry for key.
+func (m *Manifest) Set(key string, entry ManifestEntry) {
+	m.mu.Lock()
+	m.entries[key] = entry
+	m.mu.Unlock()
+}
+
+// Delete removes the entry for key, if any.
+func (m *Manifest) Delete(key string) {
+	m.mu.Lock()
+	delete(m.entries, key)
+	m.mu.Unlock()
+}
+
+// Paths returns the sorted keys held by the manifest.
+func (m *Manifest) Paths() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]string, 0, len(m.entries))
+	for key := range m.entries {
+		out = append(out, key)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// unchanged reports whether the file key in fsys still matches its entry, that
+// entry carries hash and the file already has the explicit parts of attrs,
+// which lets callers skip reading the file.
+func (m *Manifest) unchanged(fsys fs.StatFS, key string, hash string, attrs FileAttrs) bool {
+	entry, ok := m.Get(key)
+	if !ok || entry.Hash != hash {
+		return false
+	}
+	info, err := fsys.Stat(key)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	return info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime) && attrs.matches(info)
+}
+
+// record stores the current size and modification time of key in fsys.
+func (m *Manifest) record(fsys fs.StatFS, key string, hash string) error {
+	info, err := fsys.Stat(key)
+	if err != nil {
+		return fmt.Errorf("foundry: stat output file: %w", err)
+	}
+	m.Set(key, ManifestEntry{Hash: hash, Size: info.Size(), ModTime: info.ModTime()})
+	return nil
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("foundry: open source file: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("foundry: hash source file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
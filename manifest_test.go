@@ -0,0 +1,99 @@
+package foundry
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestManifestSaveLoadRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.json")
+
+	m := NewManifest()
+	entry := ManifestEntry{Hash: hashBytes([]byte("x")), Size: 1, ModTime: time.Unix(1700000000, 123).UTC()}
+	m.Set("index.html", entry)
+	if err := m.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded, err := LoadManifest(path)
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	got, ok := loaded.Get("index.html")
+	if !ok {
+		t.Fatalf("expected entry after load")
+	}
+	if got.Hash != entry.Hash || got.Size != entry.Size || !got.ModTime.Equal(entry.ModTime) {
+		t.Fatalf("got %+v want %+v", got, entry)
+	}
+}
+
+func TestLoadManifestMissingFile(t *testing.T) {
+	m, err := LoadManifest(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	if len(m.Paths()) != 0 {
+		t.Fatalf("expected empty manifest, got %v", m.Paths())
+	}
+}
+
+func TestOutputManifestSkipsAndRecovers(t *testing.T) {
+	root := t.TempDir()
+	m := NewManifest()
+	out, err := NewOutput(root, OutputOptions{Manifest: m})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+	if err := out.WriteIfChanged("page.html", []byte("v1")); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	entry, ok := m.Get("page.html")
+	if !ok || entry.Hash != hashBytes([]byte("v1")) || entry.Size != 2 {
+		t.Fatalf("unexpected manifest entry %+v", entry)
+	}
+
+	// Another process rewrites the file with the same size; the stale mtime
+	// forces a byte comparison that restores the expected content.
+	target := filepath.Join(root, "page.html")
+	if err := os.WriteFile(target, []byte("zz"), 0o644); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	stamp := entry.ModTime.Add(time.Second)
+	if err := os.Chtimes(target, stamp, stamp); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+	if err := out.WriteIfChanged("page.html", []byte("v1")); err != nil {
+		t.Fatalf("second write: %v", err)
+	}
+	data, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != "v1" {
+		t.Fatalf("expected content restored, got %q", data)
+	}
+
+	src := filepath.Join(t.TempDir(), "logo.txt")
+	if err := os.WriteFile(src, []byte("logo"), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+	if err := out.CopyFileIfChanged(src, "assets/logo.txt"); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if _, ok := m.Get("assets/logo.txt"); !ok {
+		t.Fatalf("expected copied file in manifest")
+	}
+
+	m.Set("removed.html", ManifestEntry{Hash: "old"})
+	if _, err := out.Prune(); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if want := []string{"assets/logo.txt", "page.html"}; !reflect.DeepEqual(m.Paths(), want) {
+		t.Fatalf("manifest paths got %v want %v", m.Paths(), want)
+	}
+}
@@ -0,0 +1,584 @@
+package foundry
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Transform rewrites the content of an output file before it is compared
+// with the existing file and written. key is the slash-separated output path.
+type Transform func(key string, content []byte) ([]byte, error)
+
+// MinifyOptions configures Minifiers.
+type MinifyOptions struct {
+	// Disabled makes Minifiers return no transforms, so development builds
+	// can keep readable output with the same setup code.
+	Disabled bool
+	// KeepComments keeps HTML comments. Conditional comments are always
+	// kept.
+	KeepComments bool
+}
+
+// Minifiers returns transforms for OutputOptions.Transforms that minify HTML,
+// CSS and JavaScript.
+func Minifiers(opts MinifyOptions) map[string]Transform {
+	if opts.Disabled {
+		return nil
+	}
+	return map[string]Transform{
+		"text/html": func(_ string, content []byte) ([]byte, error) {
+			return minifyHTML(content, opts.KeepComments), nil
+		},
+		"text/css": func(_ string, content []byte) ([]byte, error) {
+			return MinifyCSS(content), nil
+		},
+		"text/javascript": func(_ string, content []byte) ([]byte, error) {
+			return MinifyJS(content), nil
+		},
+	}
+}
+
+// mediaType returns the media type for key without parameters.
+func mediaType(key string) string {
+	t, _, _ := strings.Cut(ContentType(key), ";")
+	return strings.TrimSpace(t)
+}
+
+// transform applies the transform registered for the media type of key.
+func (o *Output) transform(key string, content []byte) ([]byte, error) {
+	fn := o.transformFor(key)
+	if fn == nil {
+		return content, nil
+	}
+	out, err := fn(key, content)
+	if err != nil {
+		return nil, &TransformError{Path: key, Err: err}
+	}
+	return out, nil
+}
+
+func (o *Output) transformFor(key string) Transform {
+	if len(o.transforms) == 0 {
+		return nil
+	}
+	return o.transforms[mediaType(key)]
+}
+
+// TransformError reports a Transform failure.
+type TransformError struct {
+	Path string
+	Err  error
+}
+
+func (e *TransformError) Error() string {
+	return "foundry: transform " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *TransformError) Unwrap() error {
+	return e.Err
+}
+
+// htmlBlockElements lists the elements next to which whitespace is removed.
+// Whitespace next to any other element, including li and td, which are often
+// styled inline-block, is collapsed to one space instead.
+var htmlBlockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"body": true, "br": true, "caption": true, "col": true, "colgroup": true,
+	"dd": true, "details": true, "dialog": true, "div": true, "dl": true, "dt": true,
+	"fieldset": true, "figcaption": true, "figure": true, "footer": true, "form": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"head": true, "header": true, "hgroup": true, "hr": true, "html": true,
+	"main": true, "nav": true, "ol": true, "p": true,
+	"pre": true, "section": true, "summary": true,
+	"table": true, "tbody": true, "tfoot": true, "thead": true,
+	"title": true, "tr": true, "ul": true, "!doctype": true,
+}
+
+// htmlHiddenElements render no box of their own, so whitespace on either
+// side of them still separates the surrounding words. Their tags are written
+// without touching pending whitespace, which is carried across them.
+var htmlHiddenElements = map[string]bool{
+	"base": true, "link": true, "meta": true, "noscript": true, "script": true,
+	"style": true, "template": true,
+}
+
+// htmlVerbatimElements keep their whole content, tags included, unchanged.
+var htmlVerbatimElements = map[string]bool{"pre": true, "textarea": true, "xmp": true, "plaintext": true}
+
+// MinifyHTML removes comments, collapses whitespace and drops unnecessary
+// attribute quotes. Whitespace is removed next to block-level elements and
+// collapsed to one space elsewhere; the content of pre and textarea is kept
+// as is, and inline scripts and styles are minified with MinifyJS and
+// MinifyCSS.
+func MinifyHTML(src []byte) []byte {
+	return minifyHTML(src, false)
+}
+
+func minifyHTML(src []byte, keepComments bool) []byte {
+	m := htmlMinifier{s: htmlScanner{doc: src, line: 1}, keepComments: keepComments, afterBlock: true}
+	m.out.Grow(len(src))
+	m.run()
+	return m.out.Bytes()
+}
+
+type htmlMinifier struct {
+	s            htmlScanner
+	out          bytes.Buffer
+	keepComments bool
+	// space records collapsed whitespace not yet written; afterBlock is set
+	// while the last thing written was a block-level tag.
+	space      bool
+	afterBlock bool
+}
+
+func (m *htmlMinifier) run() {
+	s := &m.s
+	for s.pos < len(s.doc) {
+		i := bytes.IndexByte(s.doc[s.pos:], '<')
+		if i < 0 {
+			i = len(s.doc) - s.pos
+		}
+		m.text(s.doc[s.pos : s.pos+i])
+		s.advance(s.pos + i)
+		if s.pos >= len(s.doc) {
+			return
+		}
+		rest := s.doc[s.pos:]
+		switch {
+		case bytes.HasPrefix(rest, []byte("<!--")):
+			start := s.pos
+			s.skipPast("-->", 4)
+			if m.keepComments || bytes.HasPrefix(rest, []byte("<!--[if")) || bytes.HasPrefix(rest, []byte("<!--<![endif]")) {
+				m.inline()
+				m.out.Write(s.doc[start:s.pos])
+			}
+		case bytes.HasPrefix(rest, []byte("<!")), bytes.HasPrefix(rest, []byte("<?")):
+			start := s.pos
+			s.skipPast(">", 2)
+			m.block(true)
+			m.out.Write(s.doc[start:s.pos])
+		case bytes.HasPrefix(rest, []byte("</")) && len(rest) > 2 && isASCIILetter(rest[2]):
+			s.advance(s.pos + 2)
+			name := strings.ToLower(s.readUntil(func(c byte) bool { return isHTMLSpace(c) || c == '>' }))
+			s.skipPast(">", 0)
+			if htmlHiddenElements[name] {
+				m.out.WriteString("</" + name + ">")
+				continue
+			}
+			m.block(htmlBlockElements[name])
+			m.out.WriteString("</" + name + ">")
+			m.afterBlock = htmlBlockElements[name]
+		case len(rest) > 1 && isASCIILetter(rest[1]):
+			m.startTag()
+		default:
+			m.text(rest[:1])
+			s.advance(s.pos + 1)
+		}
+	}
+}
+
+// text writes character data with whitespace runs collapsed.
+func (m *htmlMinifier) text(text []byte) {
+	for _, c := range text {
+		if isHTMLSpace(c) {
+			m.space = true
+			continue
+		}
+		m.inline()
+		m.out.WriteByte(c)
+	}
+}
+
+// inline writes pending whitespace before inline content.
+func (m *htmlMinifier) inline() {
+	if m.space && !m.afterBlock {
+		m.out.WriteByte(' ')
+	}
+	m.space = false
+	m.afterBlock = false
+}
+
+// block writes pending whitespace before a tag, dropping it next to
+// block-level elements.
+func (m *htmlMinifier) block(isBlock bool) {
+	if isBlock {
+		m.space = false
+	}
+	m.inline()
+}
+
+func (m *htmlMinifier) startTag() {
+	s := &m.s
+	tag := s.startTag()
+	isBlock := htmlBlockElements[tag.Name]
+	if htmlHiddenElements[tag.Name] {
+		m.writeStartTag(tag)
+	} else {
+		m.block(isBlock)
+		m.writeStartTag(tag)
+		m.afterBlock = isBlock
+	}
+
+	switch {
+	case htmlVerbatimElements[tag.Name]:
+		start := s.pos
+		s.skipRawText(tag.Name)
+		m.out.Write(s.doc[start:s.pos])
+		m.afterBlock = isBlock
+	case tag.Name == "script" || tag.Name == "style":
+		start := s.pos
+		end := len(s.doc)
+		if i := indexEndTag(s.doc[start:], tag.Name); i >= 0 {
+			end = start + i
+		}
+		m.out.Write(minifyEmbedded(tag, s.doc[start:end]))
+		s.advance(end)
+	case tag.Name == "title":
+		// Title text is plain text, so only its whitespace changes.
+		start := s.pos
+		end := len(s.doc)
+		if i := indexEndTag(s.doc[start:], "title"); i >= 0 {
+			end = start + i
+		}
+		m.out.WriteString(strings.Join(strings.Fields(string(s.doc[start:end])), " "))
+		s.advance(end)
+	}
+}
+
+func (m *htmlMinifier) writeStartTag(tag htmlTag) {
+	m.out.WriteString("<" + tag.Name)
+	unquoted := false
+	for _, a := range tag.Attrs {
+		m.out.WriteString(" " + a.Name)
+		unquoted = false
+		if a.Raw == "" {
+			continue
+		}
+		m.out.WriteByte('=')
+		switch {
+		case canUnquoteAttr(a.Raw):
+			m.out.WriteString(a.Raw)
+			unquoted = true
+		case !strings.Contains(a.Raw, `"`):
+			m.out.WriteString(`"` + a.Raw + `"`)
+		case !strings.Contains(a.Raw, "'"):
+			m.out.WriteString("'" + a.Raw + "'")
+		default:
+			m.out.WriteString(`"` + strings.ReplaceAll(a.Raw, `"`, "&quot;") + `"`)
+		}
+	}
+	if tag.SelfClosing {
+		if unquoted {
+			m.out.WriteByte(' ')
+		}
+		m.out.WriteByte('/')
+	}
+	m.out.WriteByte('>')
+}
+
+// canUnquoteAttr reports whether an attribute value is valid unquoted.
+func canUnquoteAttr(v string) bool {
+	if v == "" || strings.HasSuffix(v, "/") {
+		return false
+	}
+	return !strings.ContainsAny(v, " \t\n\r\f\"'`=<>")
+}
+
+// minifyEmbedded minifies the content of a script or style element when its
+// type is one this package understands.
+func minifyEmbedded(tag htmlTag, content []byte) []byte {
+	typ, _ := tag.attr("type")
+	t := strings.ToLower(strings.TrimSpace(typ.Val))
+	switch {
+	case tag.Name == "style" && (t == "" || t == "text/css"):
+		return MinifyCSS(content)
+	case tag.Name == "script" && (t == "" || t == "module" || t == "text/javascript" || t == "application/javascript"):
+		return MinifyJS(content)
+	case tag.Name == "script" && (t == "application/json" || t == "application/ld+json" || t == "importmap"):
+		var buf bytes.Buffer
+		if json.Compact(&buf, content) == nil {
+			return buf.Bytes()
+		}
+	}
+	return content
+}
+
+// MinifyCSS removes comments other than "/*!" license comments and
+// whitespace that does not separate tokens. Strings are kept as is.
+func MinifyCSS(src []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(src))
+	space := false
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				end = len(src)
+			} else {
+				end += i + 4
+			}
+			if i+2 < len(src) && src[i+2] == '!' {
+				writeCSSSpace(&out, space, '/')
+				space = false
+				out.Write(src[i:end])
+			} else {
+				space = true
+			}
+			i = end
+		case isHTMLSpace(c):
+			space = true
+			i++
+		case c == '"' || c == '\'':
+			end := quotedEnd(src, i)
+			writeCSSSpace(&out, space, c)
+			space = false
+			out.Write(src[i:end])
+			i = end
+		default:
+			writeCSSSpace(&out, space, c)
+			space = false
+			if c == '}' && out.Len() > 0 && out.Bytes()[out.Len()-1] == ';' {
+				out.Truncate(out.Len() - 1)
+			}
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return bytes.TrimSpace(out.Bytes())
+}
+
+// writeCSSSpace writes a pending space unless the characters on either side
+// make it redundant. A space before ":" or "(" can be significant, as in
+// "a :hover" and "and (min-width: 40em)".
+func writeCSSSpace(out *bytes.Buffer, space bool, next byte) {
+	if !space || out.Len() == 0 {
+		return
+	}
+	prev := out.Bytes()[out.Len()-1]
+	if strings.IndexByte("{};,>~:(", prev) >= 0 || strings.IndexByte("{};,>~)", next) >= 0 {
+		return
+	}
+	out.WriteByte(' ')
+}
+
+// quotedEnd returns the index after the string literal starting at src[i],
+// honouring backslash escapes. Unterminated strings end at the end of src.
+func quotedEnd(src []byte, i int) int {
+	quote := src[i]
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		}
+	}
+	return len(src)
+}
+
+// jsRegexKeywords are the keywords after which "/" starts a regular
+// expression rather than a division.
+var jsRegexKeywords = map[string]bool{
+	"await": true, "case": true, "delete": true, "do": true, "else": true, "in": true, "instanceof": true,
+	"new": true, "of": true, "return": true, "throw": true, "typeof": true, "void": true, "yield": true,
+}
+
+// MinifyJS conservatively strips JavaScript comments, indentation and
+// redundant whitespace. Line breaks are kept where they may end a statement,
+// so automatic semicolon insertion is unaffected, and strings, template
+// literals and regular expressions are copied as is. Comments starting with
+// "/*!", "//#" or "//@" are kept.
+func MinifyJS(src []byte) []byte {
+	j := jsMinifier{src: src}
+	j.out.Grow(len(src))
+	j.run()
+	return bytes.TrimSpace(j.out.Bytes())
+}
+
+type jsMinifier struct {
+	src []byte
+	out bytes.Buffer
+	// space and newline record whitespace not yet written.
+	space, newline bool
+	// templates holds, for each template literal substitution being read,
+	// the depth of braces opened inside it.
+	templates []int
+	// lastWord is the identifier or keyword that ended the output, if any.
+	lastWord string
+}
+
+func (j *jsMinifier) run() {
+	src := j.src
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n' || c == '\r':
+			j.newline = true
+			i++
+		case c == ' ' || c == '\t' || c == '\f' || c == '\v':
+			j.space = true
+			i++
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			end := bytes.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src)
+			} else {
+				end += i
+			}
+			if i+2 < len(src) && (src[i+2] == '#' || src[i+2] == '@') {
+				j.emit(src[i:end], '/')
+			}
+			i = end
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				end = len(src)
+			} else {
+				end += i + 4
+			}
+			comment := src[i:end]
+			if bytes.HasPrefix(comment, []byte("/*!")) {
+				j.emit(comment, '/')
+			} else if bytes.ContainsAny(comment, "\n\r") {
+				j.newline = true
+			} else {
+				j.space = true
+			}
+			i = end
+		case c == '"' || c == '\'':
+			end := quotedEnd(src, i)
+			j.emit(src[i:end], c)
+			i = end
+		case c == '`':
+			i = j.template(i + 1)
+		case c == '}' && len(j.templates) > 0 && j.templates[len(j.templates)-1] == 0:
+			j.templates = j.templates[:len(j.templates)-1]
+			j.emit([]byte{'}'}, '}')
+			i = j.template(i + 1)
+		case c == '/' && j.regexAllowed():
+			end := regexEnd(src, i)
+			j.emit(src[i:end], '/')
+			i = end
+		case isJSIdent(c):
+			end := i
+			for end < len(src) && isJSIdent(src[end]) {
+				end++
+			}
+			j.emit(src[i:end], c)
+			j.lastWord = string(src[i:end])
+			i = end
+		default:
+			if len(j.templates) > 0 {
+				switch c {
+				case '{':
+					j.templates[len(j.templates)-1]++
+				case '}':
+					j.templates[len(j.templates)-1]--
+				}
+			}
+			j.emit([]byte{c}, c)
+			i++
+		}
+	}
+}
+
+// emit writes a token, first writing whatever pending whitespace is needed
+// to keep it apart from the previous one.
+func (j *jsMinifier) emit(token []byte, first byte) {
+	if j.out.Len() > 0 {
+		prev := j.out.Bytes()[j.out.Len()-1]
+		switch {
+		case j.newline && strings.IndexByte("{;,", prev) < 0 && first != '}':
+			j.out.WriteByte('\n')
+		case (j.newline || j.space) && jsNeedsSpace(prev, first):
+			j.out.WriteByte(' ')
+		}
+	}
+	j.space, j.newline = false, false
+	j.lastWord = ""
+	j.out.Write(token)
+}
+
+// jsNeedsSpace reports whether whitespace between prev and next must be
+// kept. It is only dropped next to punctuation that cannot merge with its
+// neighbour into a different token.
+func jsNeedsSpace(prev, next byte) bool {
+	const safe = "{}()[];,=:"
+	return strings.IndexByte(safe, prev) < 0 && strings.IndexByte(safe, next) < 0
+}
+
+// regexAllowed reports whether a "/" at this point starts a regular
+// expression. When in doubt it says yes: copying a division verbatim is
+// harmless, while minifying inside a regular expression is not.
+func (j *jsMinifier) regexAllowed() bool {
+	if j.out.Len() == 0 {
+		return true
+	}
+	if j.lastWord != "" {
+		return jsRegexKeywords[j.lastWord]
+	}
+	prev := j.out.Bytes()[j.out.Len()-1]
+	return !isJSIdent(prev) && prev != ')' && prev != ']' && prev != '"' && prev != '\'' && prev != '`'
+}
+
+// regexEnd returns the index after the regular expression literal starting
+// at src[i]. A line break ends the scan early, since a literal cannot span
+// lines.
+func regexEnd(src []byte, i int) int {
+	inClass := false
+	for k := i + 1; k < len(src); k++ {
+		switch src[k] {
+		case '\\':
+			k++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '\n', '\r':
+			return k
+		case '/':
+			if !inClass {
+				return k + 1
+			}
+		}
+	}
+	return len(src)
+}
+
+// template copies a template literal from src[i], just after a backtick or
+// the "}" closing a substitution, up to and including the closing backtick or
+// the "${" opening the next substitution.
+func (j *jsMinifier) template(i int) int {
+	start := i
+	src := j.src
+	for ; i < len(src); i++ {
+		switch {
+		case src[i] == '\\':
+			i++
+		case src[i] == '`':
+			j.writeTemplate(src, start, i+1)
+			return i + 1
+		case src[i] == '$' && i+1 < len(src) && src[i+1] == '{':
+			j.writeTemplate(src, start, i+2)
+			j.templates = append(j.templates, 0)
+			return i + 2
+		}
+	}
+	j.writeTemplate(src, start, len(src))
+	return len(src)
+}
+
+func (j *jsMinifier) writeTemplate(src []byte, start, end int) {
+	if start > 0 && src[start-1] == '`' {
+		j.emit(src[start-1:end], '`')
+		return
+	}
+	j.out.Write(src[start:end])
+}
+
+func isJSIdent(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '$' || c >= 0x80
+}
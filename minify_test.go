@@ -0,0 +1,186 @@
+package foundry
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMinifyHTML(t *testing.T) {
+	src := `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <title>
+      Hello   World
+    </title>
+    <!-- build 42 -->
+    <!--[if IE]><p>old</p><![endif]-->
+    <style type="text/css">
+      body { color: red; }
+    </style>
+  </head>
+  <body class="home page">
+    <p>
+      Some   <em>emphasis</em> and <a href="/x" title='say "hi"'>a link</a> .
+    </p>
+    <pre>
+  keep   this
+    <b>as is</b>
+</pre>
+    <textarea name=t>
+  raw   text
+</textarea>
+    <svg viewBox="0 0 1 1"><path d="M0 0"/><circle r=1 /></svg>
+    <script>
+      // greet
+      var msg = "a  b";
+      console.log( msg );
+    </script>
+    <script type="application/ld+json">
+      { "@type": "Thing" }
+    </script>
+  </body>
+</html>
+`
+	want := `<!DOCTYPE html><html lang=en><head><meta charset=utf-8><title>Hello World</title><!--[if IE]><p>old</p><![endif]--><style type=text/css>body{color:red}</style></head><body class="home page"><p>Some <em>emphasis</em> and <a href=/x title='say "hi"'>a link</a> .</p><pre>
+  keep   this
+    <b>as is</b>
+</pre><textarea name=t>
+  raw   text
+</textarea> <svg viewbox="0 0 1 1"><path d="M0 0"/><circle r=1 /></svg><script>var msg="a  b";console.log(msg);</script><script type=application/ld+json>{"@type":"Thing"}</script></body></html>`
+	if got := string(MinifyHTML([]byte(src))); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMinifyHTMLRawTextEndTags(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{"<title> A   b </TITLE><p>x</p>", "<title>A b</title><p>x</p>"},
+		{"<SCRIPT>var s = '</scripts>';</Script >", "<script>var s='</scripts>';</script>"},
+		{"<textarea> a  </TextArea> b", "<textarea> a  </TextArea> b"},
+	}
+	for _, tt := range tests {
+		if got := string(MinifyHTML([]byte(tt.src))); got != tt.want {
+			t.Errorf("MinifyHTML(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestMinifyHTMLHiddenElementsKeepSpaces(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{"<p>Hello <script>x()</script> world</p>", "<p>Hello<script>x()</script> world</p>"},
+		{"<p>Hello <noscript>no js</noscript> world</p>", "<p>Hello<noscript> no js</noscript> world</p>"},
+		{"<p>Hello <template><b>t</b></template> world</p>", "<p>Hello<template> <b>t</b></template> world</p>"},
+		{"<p>a<link rel=x href=/y> b</p>", "<p>a<link rel=x href=/y> b</p>"},
+		{"<p>Hello<script>x()</script>world</p>", "<p>Hello<script>x()</script>world</p>"},
+		{"<div>\n  <script>x()</script>\n</div>", "<div><script>x()</script></div>"},
+	}
+	for _, tt := range tests {
+		if got := string(MinifyHTML([]byte(tt.src))); got != tt.want {
+			t.Errorf("MinifyHTML(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestMinifyCSS(t *testing.T) {
+	src := `/*! license */
+/* comment */
+@media screen and (min-width: 40em) {
+  a :hover , b > c { content: "a  /* not a comment */" ; margin: 0 auto ; }
+}
+.x { width: calc(100% - 2px); }
+`
+	want := `/*! license */ @media screen and (min-width:40em){a :hover,b>c{content:"a  /* not a comment */";margin:0 auto}}.x{width:calc(100% - 2px)}`
+	if got := string(MinifyCSS([]byte(src))); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMinifyJS(t *testing.T) {
+	src := `/*! keep me */
+// line comment
+const re = /\/\/ not a comment [/*] /g;   // trailing
+let a = b
+  / c / d;
+function f ( x ) {
+    return x ++ + + y   /* inline */ - - z;
+}
+const s = ` + "`" + `tpl  ${ { a : 1 }.a + ` + "`" + `nested  ${ x }` + "`" + ` }  end` + "`" + `;
+const t = 'it\'s  // fine'
+if (a) {
+  f(1)
+}
+//# sourceMappingURL=app.js.map
+`
+	want := `/*! keep me */
+const re=/\/\/ not a comment [/*] /g;let a=b
+/ c / d;function f(x){return x ++ + + y - - z;}
+const s=` + "`" + `tpl  ${{a:1}.a + ` + "`" + `nested  ${x}` + "`" + `}  end` + "`" + `;const t='it\'s  // fine'
+if(a){f(1)}
+//# sourceMappingURL=app.js.map`
+	if got := string(MinifyJS([]byte(src))); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestOutputTransforms(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"site.css": "a { color: red; }", "app.js": "f( 1 )", "logo.svg": "<svg> </svg>"})
+	fsys := NewMemFS()
+	out, err := NewOutputFS(fsys, OutputOptions{Transforms: Minifiers(MinifyOptions{})})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	if err := out.WriteIfChanged("index.html", []byte("<p>\n  hi\n</p>\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := out.CopyFileIfChanged(src+"/site.css", "site.css"); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if err := out.LinkFileIfChanged(src+"/app.js", "app.js"); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	if err := out.CopyFileIfChanged(src+"/logo.svg", "logo.svg"); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	for name, want := range map[string]string{"index.html": "<p>hi</p>", "site.css": "a{color:red}", "app.js": "f(1)", "logo.svg": "<svg> </svg>"} {
+		if got := readMem(t, fsys, name); got != want {
+			t.Errorf("%s = %q want %q", name, got, want)
+		}
+	}
+
+	assets, err := NewAssets(out, AssetOptions{})
+	if err != nil {
+		t.Fatalf("NewAssets: %v", err)
+	}
+	hashed, err := assets.CopyFingerprinted(src+"/site.css", "css/site.css")
+	if err != nil {
+		t.Fatalf("CopyFingerprinted: %v", err)
+	}
+	if want := fingerprintPath("css/site.css", hashBytes([]byte("a{color:red}"))[:8]); hashed != want {
+		t.Fatalf("fingerprint %s want %s", hashed, want)
+	}
+
+	if dev, err := NewOutputFS(NewMemFS(), OutputOptions{Transforms: Minifiers(MinifyOptions{Disabled: true})}); err != nil || dev.transformFor("index.html") != nil {
+		t.Fatalf("disabled minifiers still transform: %v", err)
+	}
+}
+
+func TestOutputTransformError(t *testing.T) {
+	boom := errors.New("boom")
+	out, err := NewOutputFS(NewMemFS(), OutputOptions{Transforms: map[string]Transform{
+		"TEXT/HTML": func(string, []byte) ([]byte, error) { return nil, boom },
+	}})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	err = out.WriteIfChanged("a.html", []byte("x"))
+	var terr *TransformError
+	if !errors.As(err, &terr) || terr.Path != "a.html" || !errors.Is(err, boom) {
+		t.Fatalf("expected TransformError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "transform a.html: boom") {
+		t.Fatalf("message %q", err.Error())
+	}
+}
@@ -0,0 +1,743 @@
+package foundry
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutputOptions configures an Output.
+type OutputOptions struct {
+	// Keep lists slash-separated patterns, relative to the output root, that
+	// Prune never removes. A pattern ending in "/" keeps a whole directory
+	// (for example ".well-known/"); any other pattern uses path.Match syntax
+	// against the full relative path.
+	Keep []string
+
+	// Manifest, when set, lets writes skip reading unchanged files and is
+	// updated with every path written during the build. Callers load it
+	// before the build and save it afterwards.
+	Manifest *Manifest
+
+	// DryRun makes writes, copies and Prune record what they would do
+	// without modifying the output. Changed text files keep both versions
+	// so ChangeReport can render unified diffs.
+	DryRun bool
+
+	// FileMode holds the permission bits of written files. Zero means 0644
+	// filtered by the process umask, and unchanged files narrower than that
+	// are widened to it; any other value is applied exactly, including to
+	// unchanged files.
+	FileMode fs.FileMode
+	// ModTime, when non-zero, is stamped on every written or unchanged file
+	// so that repeated builds produce identical timestamps. See
+	// SourceDateEpoch.
+	ModTime time.Time
+	// PreserveMode makes copies take their permission bits from the source
+	// file instead of FileMode.
+	PreserveMode bool
+	// PreserveModTime makes copies take their modification time from the
+	// source file instead of ModTime.
+	PreserveModTime bool
+
+	// Duplicates decides what happens when two writes in one build target
+	// the same path, or paths that differ only in case. The default,
+	// DuplicateAllow, lets the last write win.
+	Duplicates DuplicatePolicy
+
+	// Gzip, when set, writes a deterministic ".gz" companion next to every
+	// text output that qualifies, for servers that serve precompressed files.
+	Gzip *GzipOptions
+
+	// Transforms rewrite content before it is compared and written, keyed by
+	// media type without parameters, such as "text/html"; see Minifiers.
+	// Copied files with a matching type are read and written like generated
+	// content, and linked ones are copied instead. Fingerprinted assets are
+	// transformed before they are hashed.
+	Transforms map[string]Transform
+}
+
+// DuplicatePolicy controls how an Output treats a second write to a path.
+type DuplicatePolicy int
+
+const (
+	// DuplicateAllow lets later writes replace earlier ones silently.
+	DuplicateAllow DuplicatePolicy = iota
+	// DuplicateWarn logs a *DuplicateWriteError through the step logger and
+	// lets the write proceed.
+	DuplicateWarn
+	// DuplicateFail rejects the second write with a *DuplicateWriteError.
+	DuplicateFail
+)
+
+// DuplicateWriteError reports two writes in one build to the same output path
+// or to paths that differ only in case. Callers are "file:line" locations of
+// the code that called into foundry.
+type DuplicateWriteError struct {
+	Path           string
+	Caller         string
+	PreviousPath   string
+	PreviousCaller string
+}
+
+func (e *DuplicateWriteError) Error() string {
+	if e.Path == e.PreviousPath {
+		return fmt.Sprintf("foundry: %s written by %s was already written by %s", e.Path, e.Caller, e.PreviousCaller)
+	}
+	return fmt.Sprintf("foundry: %s written by %s collides with %s written by %s", e.Path, e.Caller, e.PreviousPath, e.PreviousCaller)
+}
+
+// escapeReason explains an *OutputPathError caused by a symbolic link.
+const escapeReason = "path escapes the output root through a symbolic link"
+
+// OutputPathError reports an output path that does not stay beneath the
+// output root: an empty or absolute path, one that climbs out with "..", or
+// one that leads out through a symbolic link.
+type OutputPathError struct {
+	Path   string
+	Reason string
+}
+
+func (e *OutputPathError) Error() string {
+	return fmt.Sprintf("foundry: invalid output path %q: %s", e.Path, e.Reason)
+}
+
+// Output is a build-scoped view of an output directory. It records every path
+// written or confirmed unchanged during a build so that Prune can remove files
+// left behind by earlier builds. Every path is cleaned and must stay beneath
+// the root; anything else fails with an *OutputPathError. An Output is safe for
+// concurrent use, but Prune should only be called once all writes have
+// finished.
+type Output struct {
+	fsys     OutputFS
+	keep     []string
+	manifest *Manifest
+	dryRun   bool
+	attrs    FileAttrs
+
+	preserveMode    bool
+	preserveModTime bool
+	duplicates      DuplicatePolicy
+	gzip            *GzipOptions
+	transforms      map[string]Transform
+
+	mu      sync.Mutex
+	touched map[string]struct{}
+	changes []Change
+	writers map[string]writer
+}
+
+// writer remembers the first write to a path, keyed by its folded form.
+type writer struct {
+	path   string
+	caller string
+}
+
+// NewOutput creates the output root if needed and returns an Output that
+// tracks writes beneath it.
+func NewOutput(root string, opts OutputOptions) (*Output, error) {
+	if root == "" {
+		return nil, errors.New("foundry: output root is empty")
+	}
+	if !opts.DryRun {
+		if err := EnsureDir(root); err != nil {
+			return nil, err
+		}
+	}
+	return NewOutputFS(NewDiskFS(root), opts)
+}
+
+// NewOutputFS returns an Output that writes into fsys, for example a MemFS in
+// tests.
+func NewOutputFS(fsys OutputFS, opts OutputOptions) (*Output, error) {
+	if fsys == nil {
+		return nil, errors.New("foundry: output filesystem is nil")
+	}
+	if opts.Duplicates < DuplicateAllow || opts.Duplicates > DuplicateFail {
+		return nil, fmt.Errorf("foundry: unknown duplicate policy %d", opts.Duplicates)
+	}
+	gz, err := opts.Gzip.normalize()
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range opts.Keep {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+			return nil, fmt.Errorf("foundry: invalid keep pattern %q: %w", pattern, err)
+		}
+	}
+	transforms := make(map[string]Transform, len(opts.Transforms))
+	for typ, fn := range opts.Transforms {
+		if fn == nil {
+			return nil, fmt.Errorf("foundry: transform for %q is nil", typ)
+		}
+		transforms[strings.ToLower(typ)] = fn
+	}
+	return &Output{
+		fsys:     fsys,
+		keep:     slices.Clone(opts.Keep),
+		manifest: opts.Manifest,
+		dryRun:   opts.DryRun,
+		attrs:    FileAttrs{Mode: opts.FileMode.Perm(), ModTime: opts.ModTime},
+		touched:  make(map[string]struct{}),
+
+		preserveMode:    opts.PreserveMode,
+		preserveModTime: opts.PreserveModTime,
+		duplicates:      opts.Duplicates,
+		gzip:            gz,
+		transforms:      transforms,
+		writers:         make(map[string]writer),
+	}, nil
+}
+
+// FS returns the filesystem the Output writes into.
+func (o *Output) FS() OutputFS {
+	return o.fsys
+}
+
+// Root returns the output directory, or "" when the Output is not backed by a
+// DiskFS.
+func (o *Output) Root() string {
+	if d, ok := o.fsys.(*DiskFS); ok {
+		return d.Root()
+	}
+	return ""
+}
+
+// Path returns the filesystem path for rel, a slash-separated path relative
+// to the output root. It fails when the Output is not backed by a DiskFS.
+func (o *Output) Path(rel string) (string, error) {
+	key, err := o.resolve(rel)
+	if err != nil {
+		return "", err
+	}
+	p, ok, err := o.diskPath(key)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", errors.New("foundry: output is not on disk")
+	}
+	return p, nil
+}
+
+// WriteIfChanged behaves like the package-level WriteIfChanged for the path
+// rel beneath the output root and records rel as part of the build.
+func (o *Output) WriteIfChanged(rel string, content []byte) error {
+	_, err := o.writeIfChanged(rel, content)
+	return err
+}
+
+// CopyFileIfChanged behaves like the package-level CopyFileIfChanged, copying
+// srcPath to rel beneath the output root and recording rel as part of the
+// build.
+func (o *Output) CopyFileIfChanged(srcPath string, rel string) error {
+	_, err := o.copyFileIfChanged(srcPath, rel)
+	return err
+}
+
+// LinkFileIfChanged behaves like the package-level LinkFileIfChanged for the
+// path rel beneath the output root and records rel as part of the build.
+// Linked files are not hashed into the Manifest, and because a link shares the
+// source's mode and timestamps, FileMode and ModTime do not apply to them.
+func (o *Output) LinkFileIfChanged(srcPath string, rel string) error {
+	_, err := o.linkFileIfChanged(srcPath, rel)
+	return err
+}
+
+func (o *Output) writeIfChanged(rel string, content []byte) (ChangeAction, error) {
+	key, err := o.claim(rel)
+	if err != nil {
+		return 0, err
+	}
+	if content, err = o.transform(key, content); err != nil {
+		return 0, err
+	}
+	return o.writeClaimed(key, content, o.attrs)
+}
+
+// writeClaimed writes already transformed content and its gzip companion to
+// the claimed key.
+func (o *Output) writeClaimed(key string, content []byte, attrs FileAttrs) (ChangeAction, error) {
+	action, err := o.writeKey(key, content, attrs)
+	if err != nil {
+		return 0, err
+	}
+	if err := o.precompress(key, content); err != nil {
+		return 0, err
+	}
+	return action, nil
+}
+
+// writeKey writes content to the already claimed key.
+func (o *Output) writeKey(key string, content []byte, attrs FileAttrs) (ChangeAction, error) {
+	var hash string
+	if o.manifest != nil {
+		hash = hashBytes(content)
+		if o.manifest.unchanged(o.fsys, key, hash, attrs) {
+			o.record(Change{Path: key, Action: ActionUnchanged})
+			return ActionUnchanged, nil
+		}
+	}
+
+	if o.dryRun {
+		action, existing, err := compareBytes(o.fsys, key, content)
+		if err != nil {
+			return 0, err
+		}
+		c := dryRunChange(key, action, existing, content)
+		c.Bytes = writtenBytes(action, int64(len(content)))
+		o.record(c)
+		return action, nil
+	}
+
+	action, err := writeIfChanged(o.fsys, key, content, attrs)
+	if err != nil {
+		return 0, err
+	}
+	if o.manifest != nil {
+		if err := o.manifest.record(o.fsys, key, hash); err != nil {
+			return 0, err
+		}
+	}
+	o.record(Change{Path: key, Action: action, Bytes: writtenBytes(action, int64(len(content)))})
+	return action, nil
+}
+
+func (o *Output) copyFileIfChanged(srcPath string, rel string) (ChangeAction, error) {
+	key, err := o.claim(rel)
+	if err != nil {
+		return 0, err
+	}
+	if o.transformFor(key) != nil {
+		return o.copyTransformed(srcPath, key)
+	}
+	action, err := o.copyKey(srcPath, key)
+	if err != nil {
+		return 0, err
+	}
+	if err := o.precompressFile(key, srcPath); err != nil {
+		return 0, err
+	}
+	return action, nil
+}
+
+// copyKey copies srcPath to the already claimed key.
+func (o *Output) copyKey(srcPath string, key string) (ChangeAction, error) {
+	attrs, err := o.copyAttrs(srcPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var hash string
+	if o.manifest != nil {
+		if hash, err = hashFile(srcPath); err != nil {
+			return 0, err
+		}
+		if o.manifest.unchanged(o.fsys, key, hash, attrs) {
+			o.record(Change{Path: key, Action: ActionUnchanged})
+			return ActionUnchanged, nil
+		}
+	}
+
+	if o.dryRun {
+		action, err := compareFile(o.fsys, srcPath, key)
+		if err != nil {
+			return 0, err
+		}
+		c := o.dryRunCopy(key, action, srcPath)
+		c.Bytes = copiedBytes(action, srcPath)
+		o.record(c)
+		return action, nil
+	}
+
+	action, err := copyFileIfChanged(o.fsys, srcPath, key, attrs)
+	if err != nil {
+		return 0, err
+	}
+	if o.manifest != nil {
+		if err := o.manifest.record(o.fsys, key, hash); err != nil {
+			return 0, err
+		}
+	}
+	o.record(Change{Path: key, Action: action, Bytes: copiedBytes(action, srcPath)})
+	return action, nil
+}
+
+// copyTransformed reads srcPath and writes its transformed content to the
+// claimed key.
+func (o *Output) copyTransformed(srcPath string, key string) (ChangeAction, error) {
+	attrs, err := o.copyAttrs(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	content, err := os.ReadFile(srcPath)
+	if err != nil {
+		return 0, fmt.Errorf("foundry: read source file: %w", err)
+	}
+	if content, err = o.transform(key, content); err != nil {
+		return 0, err
+	}
+	return o.writeClaimed(key, content, attrs)
+}
+
+// copiedBytes returns the size of srcPath when action wrote it to the output.
+func copiedBytes(action ChangeAction, srcPath string) int64 {
+	if !action.writes() {
+		return 0
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// copyAttrs returns the attributes for a copy of srcPath, taking the mode and
+// modification time from the source when the options ask for it.
+func (o *Output) copyAttrs(srcPath string) (FileAttrs, error) {
+	attrs := o.attrs
+	if !o.preserveMode && !o.preserveModTime {
+		return attrs, nil
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return FileAttrs{}, fmt.Errorf("foundry: stat source file: %w", err)
+	}
+	if o.preserveMode {
+		attrs.Mode = info.Mode().Perm()
+	}
+	if o.preserveModTime {
+		attrs.ModTime = info.ModTime()
+	}
+	return attrs, nil
+}
+
+// linkFileIfChanged hard links srcPath into a disk output and copies it into
+// any other OutputFS.
+func (o *Output) linkFileIfChanged(srcPath string, rel string) (ChangeAction, error) {
+	key, err := o.claim(rel)
+	if err != nil {
+		return 0, err
+	}
+	if o.transformFor(key) != nil {
+		return o.copyTransformed(srcPath, key)
+	}
+	action, err := o.linkKey(srcPath, key)
+	if err != nil {
+		return 0, err
+	}
+	if err := o.precompressFile(key, srcPath); err != nil {
+		return 0, err
+	}
+	return action, nil
+}
+
+// linkKey links or copies srcPath to the already claimed key.
+func (o *Output) linkKey(srcPath string, key string) (ChangeAction, error) {
+	p, ok, err := o.diskPath(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return o.copyKey(srcPath, key)
+	}
+
+	var action ChangeAction
+	if o.dryRun {
+		action, err = compareLink(srcPath, p)
+	} else {
+		action, err = linkFileIfChanged(srcPath, p)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if o.manifest != nil && !o.dryRun {
+		o.manifest.Delete(key)
+	}
+	o.record(Change{Path: key, Action: action, Bytes: copiedBytes(action, srcPath)})
+	return action, nil
+}
+
+// dryRunCopy reads both sides of a planned copy so that text assets can be
+// diffed.
+func (o *Output) dryRunCopy(key string, action ChangeAction, srcPath string) Change {
+	if action == ActionUnchanged {
+		return Change{Path: key, Action: action}
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil || info.Size() > maxDiffBytes {
+		return Change{Path: key, Action: action}
+	}
+	after, err := os.ReadFile(srcPath)
+	if err != nil {
+		return Change{Path: key, Action: action}
+	}
+	var before []byte
+	if action == ActionUpdate {
+		if info, err := o.fsys.Stat(key); err != nil || info.Size() > maxDiffBytes {
+			return Change{Path: key, Action: action}
+		}
+		if before, err = fs.ReadFile(o.fsys, key); err != nil {
+			return Change{Path: key, Action: action}
+		}
+	}
+	return dryRunChange(key, action, before, after)
+}
+
+// Touch records rel as part of the build without writing it. Use it for files
+// produced by external tools so that Prune leaves them alone.
+func (o *Output) Touch(rel string) error {
+	key, err := o.resolve(rel)
+	if err != nil {
+		return err
+	}
+	o.touch(key)
+	return nil
+}
+
+// ChangeReport returns what every write, copy and Prune call did (or, in a
+// dry run, would do) so far.
+func (o *Output) ChangeReport() *ChangeReport {
+	o.mu.Lock()
+	changes := slices.Clone(o.changes)
+	o.mu.Unlock()
+	return newChangeReport(changes)
+}
+
+// Touched returns the sorted, slash-separated paths recorded so far.
+func (o *Output) Touched() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := make([]string, 0, len(o.touched))
+	for key := range o.touched {
+		out = append(out, key)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// Prune removes every file beneath the output root that was not recorded
+// during this build and does not match a Keep pattern, then removes any
+// directories left empty. It returns the sorted relative paths of the removed
+// files. Manifest entries for paths not recorded during this build are dropped.
+// In a dry run nothing is removed and the returned paths are those Prune would
+// remove.
+func (o *Output) Prune() ([]string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var removed []string
+	var dirs []string
+	err := fs.WalkDir(o.fsys, ".", func(key string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if key == "." {
+			return nil
+		}
+		if o.kept(key) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			dirs = append(dirs, key)
+			return nil
+		}
+		if _, ok := o.touched[key]; ok {
+			return nil
+		}
+		if o.dryRun {
+			before, _ := fs.ReadFile(o.fsys, key)
+			o.changes = append(o.changes, dryRunChange(key, ActionRemove, before, nil))
+			removed = append(removed, key)
+			return nil
+		}
+		if err := o.fsys.Remove(key); err != nil {
+			return err
+		}
+		o.changes = append(o.changes, Change{Path: key, Action: ActionRemove})
+		removed = append(removed, key)
+		return nil
+	})
+	if o.dryRun {
+		if errors.Is(err, fs.ErrNotExist) {
+			// A dry run against an output that was never built.
+			err = nil
+		}
+		if err != nil {
+			return removed, fmt.Errorf("foundry: prune output: %w", err)
+		}
+		slices.Sort(removed)
+		return removed, nil
+	}
+	if err != nil {
+		return removed, fmt.Errorf("foundry: prune output: %w", err)
+	}
+
+	// WalkDir visits parents before children, so walking the list backwards
+	// empties nested directories before their parents are considered.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := fs.ReadDir(o.fsys, dirs[i])
+		if errors.Is(err, fs.ErrNotExist) {
+			// Filesystems with implicit directories drop them with their
+			// last file.
+			continue
+		}
+		if err != nil {
+			return removed, fmt.Errorf("foundry: prune output: %w", err)
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		if err := o.fsys.Remove(dirs[i]); err != nil {
+			return removed, fmt.Errorf("foundry: prune output: %w", err)
+		}
+	}
+
+	if o.manifest != nil {
+		for _, key := range o.manifest.Paths() {
+			if _, ok := o.touched[key]; !ok {
+				o.manifest.Delete(key)
+			}
+		}
+	}
+
+	slices.Sort(removed)
+	return removed, nil
+}
+
+// resolve turns rel into the slash-separated key used for tracking and for
+// addressing o.fsys. It cleans rel and rejects anything that is not a path
+// strictly beneath the output root with an *OutputPathError.
+func (o *Output) resolve(rel string) (string, error) {
+	if rel == "" {
+		return "", &OutputPathError{Path: rel, Reason: "path is empty"}
+	}
+	if filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" || strings.HasPrefix(filepath.ToSlash(rel), "/") {
+		return "", &OutputPathError{Path: rel, Reason: "path is absolute"}
+	}
+	key := path.Clean(filepath.ToSlash(rel))
+	if key == "." {
+		return "", &OutputPathError{Path: rel, Reason: "path names the output root"}
+	}
+	if key == ".." || strings.HasPrefix(key, "../") {
+		return "", &OutputPathError{Path: rel, Reason: "path escapes the output root"}
+	}
+	if !fs.ValidPath(key) {
+		return "", &OutputPathError{Path: rel, Reason: "path is not valid"}
+	}
+	return key, nil
+}
+
+// claim resolves rel for a write and applies the duplicate policy against
+// earlier writes in this build.
+func (o *Output) claim(rel string) (string, error) {
+	key, err := o.resolve(rel)
+	if err != nil || o.duplicates == DuplicateAllow {
+		return key, err
+	}
+	caller := externalCaller()
+	folded := strings.ToLower(key)
+
+	o.mu.Lock()
+	prev, ok := o.writers[folded]
+	if !ok {
+		o.writers[folded] = writer{path: key, caller: caller}
+	}
+	o.mu.Unlock()
+	if !ok {
+		return key, nil
+	}
+
+	dup := &DuplicateWriteError{Path: key, Caller: caller, PreviousPath: prev.path, PreviousCaller: prev.caller}
+	if o.duplicates == DuplicateWarn {
+		logf("warning: %v", dup)
+		return key, nil
+	}
+	return "", dup
+}
+
+// diskPath returns the filesystem path for key when the Output is backed by a
+// DiskFS, failing if a symbolic link would lead it out of the root.
+func (o *Output) diskPath(key string) (string, bool, error) {
+	d, ok := o.fsys.(*DiskFS)
+	if !ok {
+		return "", false, nil
+	}
+	p, err := d.hostPath(key)
+	return p, true, err
+}
+
+func (o *Output) touch(key string) {
+	o.mu.Lock()
+	o.touched[key] = struct{}{}
+	o.mu.Unlock()
+}
+
+func (o *Output) isTouched(key string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, ok := o.touched[key]
+	return ok
+}
+
+// record marks c.Path as part of the build and keeps c for ChangeReport.
+func (o *Output) record(c Change) {
+	o.mu.Lock()
+	o.touched[c.Path] = struct{}{}
+	o.changes = append(o.changes, c)
+	o.mu.Unlock()
+}
+
+// recordRemoved keeps c for ChangeReport without marking its path as part of
+// the build.
+func (o *Output) recordRemoved(c Change) {
+	o.mu.Lock()
+	o.changes = append(o.changes, c)
+	o.mu.Unlock()
+}
+
+// packagePrefix prefixes the names of functions in this package.
+var packagePrefix = reflect.TypeFor[Output]().PkgPath() + "."
+
+// externalCaller returns the "file:line" of the nearest caller outside this
+// package, so duplicate write errors point at the build code responsible.
+func externalCaller() string {
+	var pcs [32]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs[:])])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePrefix) || strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "unknown caller"
+		}
+	}
+}
+
+func (o *Output) kept(key string) bool {
+	for _, pattern := range o.keep {
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(key+"/", pattern) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
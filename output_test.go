@@ -0,0 +1,180 @@
+package foundry
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOutputPruneRemovesOrphans(t *testing.T) {
+	root := t.TempDir()
+	for _, rel := range []string{"old.html", "gone/page.html", "keep/me.html"} {
+		if err := WriteIfChanged(filepath.Join(root, rel), []byte("stale")); err != nil {
+			t.Fatalf("seed %s: %v", rel, err)
+		}
+	}
+
+	out, err := NewOutput(root, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+	if err := out.WriteIfChanged("index.html", []byte("home")); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	if err := out.WriteIfChanged("keep/me.html", []byte("fresh")); err != nil {
+		t.Fatalf("write keep: %v", err)
+	}
+
+	removed, err := out.Prune()
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if want := []string{"gone/page.html", "old.html"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removed got %v want %v", removed, want)
+	}
+	if _, err := os.Stat(filepath.Join(root, "gone")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected empty directory to be removed, got %v", err)
+	}
+	for _, rel := range []string{"index.html", "keep/me.html"} {
+		if _, err := os.Stat(filepath.Join(root, rel)); err != nil {
+			t.Fatalf("expected %s to survive prune: %v", rel, err)
+		}
+	}
+}
+
+func TestOutputPruneKeepPatterns(t *testing.T) {
+	root := t.TempDir()
+	for _, rel := range []string{".well-known/security.txt", "CNAME", "stale.html"} {
+		if err := WriteIfChanged(filepath.Join(root, rel), []byte("x")); err != nil {
+			t.Fatalf("seed %s: %v", rel, err)
+		}
+	}
+
+	out, err := NewOutput(root, OutputOptions{Keep: []string{".well-known/", "CNAME"}})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+	removed, err := out.Prune()
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if want := []string{"stale.html"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removed got %v want %v", removed, want)
+	}
+}
+
+func TestOutputConcurrentWrites(t *testing.T) {
+	root := t.TempDir()
+	out, err := NewOutput(root, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+
+	var items []int
+	for i := 0; i < 50; i++ {
+		items = append(items, i)
+	}
+	if err := ForEachParallel(items, 8, func(i int) {
+		if err := out.WriteIfChanged(filepath.Join("pages", strconv.Itoa(i)+".html"), []byte("p")); err != nil {
+			panic(err)
+		}
+	}); err != nil {
+		t.Fatalf("ForEachParallel: %v", err)
+	}
+
+	if got := len(out.Touched()); got != len(items) {
+		t.Fatalf("touched got %d want %d", got, len(items))
+	}
+	removed, err := out.Prune()
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("expected nothing pruned, got %v", removed)
+	}
+}
+
+func TestNewOutputInvalidArgs(t *testing.T) {
+	if _, err := NewOutput("", OutputOptions{}); err == nil {
+		t.Fatalf("expected error for empty root")
+	}
+	if _, err := NewOutput(t.TempDir(), OutputOptions{Keep: []string{"["}}); err == nil {
+		t.Fatalf("expected error for bad keep pattern")
+	}
+}
+
+func TestOutputDuplicateWritesFail(t *testing.T) {
+	out, err := NewOutputFS(NewMemFS(), OutputOptions{Duplicates: DuplicateFail})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	if err := out.WriteIfChanged("about.html", []byte("one")); err != nil {
+		t.Fatalf("first write: %v", err)
+	}
+	for _, rel := range []string{"about.html", "About.html"} {
+		err := out.WriteIfChanged(rel, []byte("two"))
+		var dup *DuplicateWriteError
+		if !errors.As(err, &dup) {
+			t.Fatalf("%s: expected DuplicateWriteError, got %v", rel, err)
+		}
+		if dup.Path != rel || dup.PreviousPath != "about.html" {
+			t.Fatalf("unexpected paths %+v", dup)
+		}
+		if !strings.Contains(dup.Caller, "output_test.go:") || !strings.Contains(dup.PreviousCaller, "output_test.go:") {
+			t.Fatalf("expected test callers, got %+v", dup)
+		}
+	}
+	data, err := fs.ReadFile(out.FS(), "about.html")
+	if err != nil || string(data) != "one" {
+		t.Fatalf("duplicate write modified output: %q %v", data, err)
+	}
+}
+
+func TestOutputDuplicateWritesWarn(t *testing.T) {
+	var logs bytes.Buffer
+	SetStepLogger(log.New(&logs, "", 0))
+	defer SetStepLogger(nil)
+
+	out, err := NewOutputFS(NewMemFS(), OutputOptions{Duplicates: DuplicateWarn})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	pages := []string{"a.html", "b.html", "A.html"}
+	if err := ForEachParallel(pages, 3, func(rel string) {
+		if err := out.WriteIfChanged(rel, []byte(rel)); err != nil {
+			panic(err)
+		}
+	}); err != nil {
+		t.Fatalf("ForEachParallel: %v", err)
+	}
+	if !strings.Contains(logs.String(), "collides with") {
+		t.Fatalf("expected collision warning, got %q", logs.String())
+	}
+}
+
+func TestOutputRejectsUnsafePaths(t *testing.T) {
+	out, err := NewOutputFS(NewMemFS(), OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	for _, rel := range []string{"", ".", "/etc/passwd", "../x.html", "a/../../x.html", "blog/../.."} {
+		err := out.WriteIfChanged(rel, []byte("x"))
+		var pathErr *OutputPathError
+		if !errors.As(err, &pathErr) || pathErr.Path != rel {
+			t.Fatalf("%q: expected OutputPathError, got %v", rel, err)
+		}
+	}
+	if err := out.WriteIfChanged("blog/./drafts/../post.html", []byte("x")); err != nil {
+		t.Fatalf("clean path: %v", err)
+	}
+	if got := out.Touched(); !reflect.DeepEqual(got, []string{"blog/post.html"}) {
+		t.Fatalf("touched got %v", got)
+	}
+}
@@ -0,0 +1,512 @@
+package foundry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+// OutputFS is a writable filesystem that the file primitives can target. Names
+// follow io/fs conventions: slash-separated, unrooted and free of "." or ".."
+// elements. Implementations must be safe for concurrent use.
+type OutputFS interface {
+	fs.StatFS
+
+	// WriteFile replaces name with the contents of r, creating parent
+	// directories as needed. Readers must never observe a partial file.
+	// Implementations apply attrs as documented on FileAttrs.
+	WriteFile(name string, r io.Reader, attrs FileAttrs) error
+	// SetAttrs applies the explicit parts of attrs to the existing file name.
+	SetAttrs(name string, attrs FileAttrs) error
+	// Remove deletes a file or an empty directory.
+	Remove(name string) error
+	// MkdirAll creates the directory name and any missing parents.
+	MkdirAll(name string) error
+}
+
+// WriteIfChangedFS is WriteIfChanged for a file inside fsys.
+func WriteIfChangedFS(fsys OutputFS, name string, content []byte) error {
+	_, err := writeIfChanged(fsys, name, content, FileAttrs{})
+	return err
+}
+
+// CopyFileIfChangedFS is CopyFileIfChanged for a destination inside fsys. The
+// source is still read from the operating system's filesystem.
+func CopyFileIfChangedFS(fsys OutputFS, srcPath string, name string) error {
+	_, err := copyFileIfChanged(fsys, srcPath, name, FileAttrs{})
+	return err
+}
+
+// EnsureDirFS is EnsureDir for a directory inside fsys.
+func EnsureDirFS(fsys OutputFS, name string) error {
+	if name == "" {
+		return errors.New("foundry: directory path is empty")
+	}
+	return fsys.MkdirAll(name)
+}
+
+// DiskFS is an OutputFS rooted at a directory on disk. Writes go through a
+// temporary file and a rename, exactly like WriteIfChanged. Every operation is
+// confined to the directory with an os.Root, so a symbolic link inside it
+// cannot redirect a write elsewhere; such attempts fail with an
+// *OutputPathError.
+type DiskFS struct {
+	root string
+	// breakLinks makes SetAttrs rewrite files instead of changing them in
+	// place, for staging trees whose files are hard links to the live output.
+	breakLinks bool
+
+	mu sync.Mutex
+	r  *os.Root
+}
+
+// NewDiskFS returns an OutputFS for the directory root. The directory is
+// created on first write if it does not exist.
+func NewDiskFS(root string) *DiskFS {
+	return &DiskFS{root: filepath.Clean(root)}
+}
+
+// Root returns the directory the filesystem is rooted at.
+func (d *DiskFS) Root() string {
+	return d.root
+}
+
+// Open implements fs.FS.
+func (d *DiskFS) Open(name string) (fs.File, error) {
+	fsys, err := d.readFS("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fsys.Open(name)
+	return f, d.check("open", name, err)
+}
+
+// Stat implements fs.StatFS.
+func (d *DiskFS) Stat(name string) (fs.FileInfo, error) {
+	fsys, err := d.readFS("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(fsys, name)
+	return info, d.check("stat", name, err)
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (d *DiskFS) ReadFile(name string) ([]byte, error) {
+	fsys, err := d.readFS("read", name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fs.ReadFile(fsys, name)
+	return data, d.check("read", name, err)
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (d *DiskFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fsys, err := d.readFS("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(fsys, name)
+	return entries, d.check("readdir", name, err)
+}
+
+// WriteFile implements OutputFS.
+func (d *DiskFS) WriteFile(name string, r io.Reader, attrs FileAttrs) error {
+	if name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	root, err := d.openRoot("write", name, true)
+	if err != nil {
+		return err
+	}
+	p := filepath.FromSlash(name)
+	if dir := filepath.Dir(p); dir != "." {
+		if err := root.MkdirAll(dir, 0o755); err != nil {
+			return d.check("write", name, fmt.Errorf("foundry: ensure dir: %w", err))
+		}
+	}
+	return d.check("write", name, writeAtomic(root, p, r, attrs))
+}
+
+// SetAttrs implements OutputFS.
+func (d *DiskFS) SetAttrs(name string, attrs FileAttrs) error {
+	root, err := d.openRoot("chmod", name, false)
+	if err != nil {
+		return err
+	}
+	if d.breakLinks {
+		return d.check("chmod", name, rewriteWithAttrs(root, filepath.FromSlash(name), attrs))
+	}
+	return d.check("chmod", name, setDiskAttrs(root, filepath.FromSlash(name), attrs))
+}
+
+// Remove implements OutputFS.
+func (d *DiskFS) Remove(name string) error {
+	root, err := d.openRoot("remove", name, false)
+	if err != nil {
+		return err
+	}
+	return d.check("remove", name, root.Remove(filepath.FromSlash(name)))
+}
+
+// MkdirAll implements OutputFS.
+func (d *DiskFS) MkdirAll(name string) error {
+	root, err := d.openRoot("mkdir", name, true)
+	if err != nil {
+		return err
+	}
+	if err := root.MkdirAll(filepath.FromSlash(name), 0o755); err != nil {
+		return d.check("mkdir", name, fmt.Errorf("foundry: ensure dir: %w", err))
+	}
+	return nil
+}
+
+// Close releases the directory handle held by d. It is reopened on the next
+// operation, so Close only matters before renaming or deleting the root,
+// which some platforms refuse while a handle is open.
+func (d *DiskFS) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.r == nil {
+		return nil
+	}
+	err := d.r.Close()
+	d.r = nil
+	return err
+}
+
+// hostPath returns the operating system path for name after checking that
+// its parent directory does not lead out of the root, for operations such as
+// hard links that os.Root cannot perform.
+func (d *DiskFS) hostPath(name string) (string, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return "", &fs.PathError{Op: "link", Path: name, Err: fs.ErrInvalid}
+	}
+	if d.escapes(path.Dir(name)) {
+		return "", &OutputPathError{Path: name, Reason: escapeReason}
+	}
+	return filepath.Join(d.root, filepath.FromSlash(name)), nil
+}
+
+// readFS returns the root as an fs.FS for read operations.
+func (d *DiskFS) readFS(op string, name string) (fs.FS, error) {
+	root, err := d.openRoot(op, name, false)
+	if err != nil {
+		return nil, err
+	}
+	return root.FS(), nil
+}
+
+// openRoot validates name and returns the os.Root for the directory,
+// creating the directory first when create is set. A missing directory is
+// reported as fs.ErrNotExist and retried on the next call.
+func (d *DiskFS) openRoot(op string, name string, create bool) (*os.Root, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.r != nil {
+		return d.r, nil
+	}
+	if create {
+		if err := EnsureDir(d.root); err != nil {
+			return nil, err
+		}
+	}
+	r, err := os.OpenRoot(d.root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("foundry: open output root: %w", err)
+	}
+	d.r = r
+	return r, nil
+}
+
+// check turns a failure caused by a symbolic link that leaves the root into
+// an *OutputPathError and returns any other error unchanged.
+func (d *DiskFS) check(op string, name string, err error) error {
+	if err == nil || errors.Is(err, fs.ErrNotExist) || !d.escapes(name) {
+		return err
+	}
+	return &OutputPathError{Path: name, Reason: escapeReason}
+}
+
+// maxSymlinkHops bounds symbolic link resolution in escapes, like the
+// operating system's own loop limit.
+const maxSymlinkHops = 40
+
+// escapes reports whether resolving name inside the root follows a symbolic
+// link out of it. Absolute link targets count as escapes because os.Root
+// refuses them.
+func (d *DiskFS) escapes(name string) bool {
+	pending := strings.Split(name, "/")
+	var resolved []string
+	for hops := 0; len(pending) > 0; {
+		elem := pending[0]
+		pending = pending[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return true
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+		host := filepath.Join(d.root, filepath.Join(append(resolved, elem)...))
+		info, err := os.Lstat(host)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			resolved = append(resolved, elem)
+			continue
+		}
+		target, err := os.Readlink(host)
+		if hops++; err != nil || hops > maxSymlinkHops {
+			return false
+		}
+		if filepath.IsAbs(target) {
+			return true
+		}
+		pending = append(strings.Split(filepath.ToSlash(target), "/"), pending...)
+	}
+	return false
+}
+
+// MemFS is an in-memory OutputFS. Its contents can be inspected through the
+// io/fs interfaces it implements or as a testing/fstest.MapFS via MapFS.
+type MemFS struct {
+	mu    sync.RWMutex
+	files fstest.MapFS
+	// descendants counts the entries below each directory, so lookups of
+	// implicit directories do not scan every file.
+	descendants map[string]int
+}
+
+// NewMemFS returns an empty in-memory filesystem.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(fstest.MapFS), descendants: make(map[string]int)}
+}
+
+// MapFS returns a snapshot of the filesystem. Later writes do not affect it.
+func (m *MemFS) MapFS() fstest.MapFS {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	snapshot := make(fstest.MapFS, len(m.files))
+	for name, file := range m.files {
+		snapshot[name] = file
+	}
+	return snapshot
+}
+
+// Open implements fs.FS.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	view, err := m.view("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return view.Open(name)
+}
+
+// Stat implements fs.StatFS.
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	view, err := m.view("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return view.Stat(name)
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	view, err := m.view("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return view.ReadFile(name)
+}
+
+// view returns a MapFS for looking up name without copying the whole tree:
+// a regular file is served from a single-entry map, and only directories,
+// whose listings need every entry, get a full snapshot.
+func (m *MemFS) view(op string, name string) (fstest.MapFS, error) {
+	m.mu.RLock()
+	file, ok := m.files[name]
+	isDir := name == "." || m.descendants[name] > 0 || ok && file.Mode.IsDir()
+	m.mu.RUnlock()
+	switch {
+	case isDir:
+		return m.MapFS(), nil
+	case ok:
+		return fstest.MapFS{name: file}, nil
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return m.MapFS().ReadDir(name)
+}
+
+// WriteFile implements OutputFS.
+func (m *MemFS) WriteFile(name string, r io.Reader, attrs FileAttrs) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkParents("write", name); err != nil {
+		return err
+	}
+	if existing, ok := m.files[name]; ok && existing.Mode.IsDir() || m.descendants[name] > 0 {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+	file := &fstest.MapFile{Data: data, Mode: defaultFileMode, ModTime: time.Now()}
+	applyMemAttrs(file, attrs)
+	m.add(name, file)
+	return nil
+}
+
+// SetAttrs implements OutputFS.
+func (m *MemFS) SetAttrs(name string, attrs FileAttrs) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.files[name]
+	if !ok {
+		return &fs.PathError{Op: "chmod", Path: name, Err: fs.ErrNotExist}
+	}
+	// Replace rather than mutate: snapshots returned by MapFS share entries.
+	file := *existing
+	applyMemAttrs(&file, attrs)
+	m.files[name] = &file
+	return nil
+}
+
+// Remove implements OutputFS.
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.hasChildren(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if m.descendants[dir]--; m.descendants[dir] == 0 {
+			delete(m.descendants, dir)
+		}
+	}
+	return nil
+}
+
+// MkdirAll implements OutputFS.
+func (m *MemFS) MkdirAll(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkParents("mkdir", name); err != nil {
+		return err
+	}
+	if existing, ok := m.files[name]; ok {
+		if existing.Mode.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	m.add(name, &fstest.MapFile{Mode: fs.ModeDir | 0o755, ModTime: time.Now()})
+	return nil
+}
+
+func applyMemAttrs(file *fstest.MapFile, attrs FileAttrs) {
+	if attrs.Mode != 0 {
+		file.Mode = file.Mode.Type() | attrs.Mode.Perm()
+	}
+	if !attrs.ModTime.IsZero() {
+		file.ModTime = attrs.ModTime
+	}
+}
+
+// checkParents rejects names whose parent directories already exist as files.
+func (m *MemFS) checkParents(op string, name string) error {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if file, ok := m.files[dir]; ok && !file.Mode.IsDir() {
+			return &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+		}
+	}
+	return nil
+}
+
+// add stores file at name, counting it in its parents when it is new.
+func (m *MemFS) add(name string, file *fstest.MapFile) {
+	if _, ok := m.files[name]; !ok {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			m.descendants[dir]++
+		}
+	}
+	m.files[name] = file
+}
+
+func (m *MemFS) hasChildren(dir string) bool {
+	return m.descendants[dir] > 0
+}
+
+// osFS adapts the operating system's filesystem, addressed by ordinary paths,
+// so the package-level primitives share the OutputFS code paths.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFS) WriteFile(name string, r io.Reader, attrs FileAttrs) error {
+	dir := filepath.Dir(name)
+	if dir != "." && dir != "" {
+		if err := EnsureDir(dir); err != nil {
+			return err
+		}
+	}
+	return writeAtomic(hostOps{}, name, r, attrs)
+}
+
+func (osFS) SetAttrs(name string, attrs FileAttrs) error {
+	return setDiskAttrs(hostOps{}, name, attrs)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) MkdirAll(name string) error {
+	return EnsureDir(name)
+}
@@ -0,0 +1,188 @@
+package foundry
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMemFSWriteIfChanged(t *testing.T) {
+	mem := NewMemFS()
+	if err := WriteIfChangedFS(mem, "en/index.html", []byte("hello")); err != nil {
+		t.Fatalf("WriteIfChangedFS: %v", err)
+	}
+	info1, err := mem.Stat("en/index.html")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := WriteIfChangedFS(mem, "en/index.html", []byte("hello")); err != nil {
+		t.Fatalf("second write: %v", err)
+	}
+	info2, err := mem.Stat("en/index.html")
+	if err != nil {
+		t.Fatalf("stat second: %v", err)
+	}
+	if !info1.ModTime().Equal(info2.ModTime()) {
+		t.Fatalf("modification time changed on identical content")
+	}
+	if err := EnsureDirFS(mem, "assets/img"); err != nil {
+		t.Fatalf("EnsureDirFS: %v", err)
+	}
+
+	if err := fstest.TestFS(mem, "en/index.html", "assets/img"); err != nil {
+		t.Fatalf("TestFS: %v", err)
+	}
+}
+
+func TestMemFSImplicitDirectories(t *testing.T) {
+	mem := NewMemFS()
+	for _, name := range []string{"blog/a/index.html", "blog/b/index.html"} {
+		if err := WriteIfChangedFS(mem, name, []byte(name)); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if info, err := mem.Stat("blog"); err != nil || !info.IsDir() {
+		t.Fatalf("expected implicit directory, got %v %v", info, err)
+	}
+	if err := mem.Remove("blog/a/index.html"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := mem.Stat("blog/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected emptied directory to vanish, got %v", err)
+	}
+	if err := mem.Remove("blog"); err == nil {
+		t.Fatal("expected error removing non-empty directory")
+	}
+	entries, err := mem.ReadDir("blog")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "b" {
+		t.Fatalf("unexpected listing %v %v", entries, err)
+	}
+	if data, err := mem.ReadFile("blog/b/index.html"); err != nil || string(data) != "blog/b/index.html" {
+		t.Fatalf("ReadFile got %q %v", data, err)
+	}
+}
+
+func TestMemFSRejectsInvalidNames(t *testing.T) {
+	mem := NewMemFS()
+	for _, name := range []string{"../x", "/abs", "a/./b"} {
+		if err := WriteIfChangedFS(mem, name, []byte("x")); err == nil {
+			t.Fatalf("expected error for %q", name)
+		}
+	}
+	if err := WriteIfChangedFS(mem, "file", []byte("x")); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := WriteIfChangedFS(mem, "file/child", []byte("x")); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expected ErrExist writing beneath a file, got %v", err)
+	}
+	if err := WriteIfChangedFS(mem, "dir/child", []byte("x")); err != nil {
+		t.Fatalf("write dir/child: %v", err)
+	}
+	for _, write := range []func() error{
+		func() error { return WriteIfChangedFS(mem, "dir", []byte("x")) },
+		func() error { return mem.WriteFile("dir", strings.NewReader("x"), FileAttrs{}) },
+	} {
+		var pathErr *fs.PathError
+		if err := write(); !errors.As(err, &pathErr) || pathErr.Op != "write" || !errors.Is(err, fs.ErrExist) {
+			t.Fatalf("expected write ErrExist over an implicit directory, got %v", err)
+		}
+	}
+}
+
+func TestCopyFileIfChangedFS(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "logo.svg")
+	if err := os.WriteFile(src, []byte("<svg/>"), 0o644); err != nil {
+		t.Fatalf("write src: %v", err)
+	}
+	mem := NewMemFS()
+	if err := CopyFileIfChangedFS(mem, src, "img/logo.svg"); err != nil {
+		t.Fatalf("CopyFileIfChangedFS: %v", err)
+	}
+	if got := string(mem.MapFS()["img/logo.svg"].Data); got != "<svg/>" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestOutputOnMemFS(t *testing.T) {
+	mem := NewMemFS()
+	if err := WriteIfChangedFS(mem, "stale/old.html", []byte("old")); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	out, err := NewOutputFS(mem, OutputOptions{Manifest: NewManifest()})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	if err := out.WriteIfChanged("index.html", []byte("home")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := out.Path("index.html"); err == nil {
+		t.Fatalf("expected Path to fail for in-memory output")
+	}
+	removed, err := out.Prune()
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if want := []string{"stale/old.html"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removed got %v want %v", removed, want)
+	}
+	if _, err := mem.Stat("stale"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected empty directory removed, got %v", err)
+	}
+}
+
+func TestDiskFSRejectsInvalidNames(t *testing.T) {
+	disk := NewDiskFS(t.TempDir())
+	if err := disk.WriteFile("../escape", nil, FileAttrs{}); !errors.Is(err, fs.ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, got %v", err)
+	}
+}
+
+func TestDiskFSRejectsSymlinkEscape(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "abs")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	if err := os.Symlink(filepath.Join("..", filepath.Base(outside)), filepath.Join(root, "rel")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "real"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Symlink("real", filepath.Join(root, "inside")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	out, err := NewOutput(root, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutput: %v", err)
+	}
+	for _, rel := range []string{"abs/x.html", "rel/x.html", "rel/sub/x.html"} {
+		err := out.WriteIfChanged(rel, []byte("x"))
+		var pathErr *OutputPathError
+		if !errors.As(err, &pathErr) {
+			t.Fatalf("%s: expected OutputPathError, got %v", rel, err)
+		}
+		if err := out.LinkFileIfChanged(filepath.Join(root, "real"), rel); !errors.As(err, &pathErr) {
+			t.Fatalf("%s: expected OutputPathError from link, got %v", rel, err)
+		}
+	}
+	entries, err := os.ReadDir(outside)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("write escaped the root: %v %v", entries, err)
+	}
+
+	if err := out.WriteIfChanged("inside/x.html", []byte("x")); err != nil {
+		t.Fatalf("write through in-root link: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "real", "x.html")); err != nil {
+		t.Fatalf("expected file behind in-root link: %v", err)
+	}
+}
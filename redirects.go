@@ -0,0 +1,276 @@
+package foundry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const defaultRedirectStatus = 301
+
+var redirectStub = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Redirecting…</title>
+<link rel="canonical" href="{{.Canonical}}">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="0; url={{.To}}">
+</head>
+<body>
+<p>This page has moved to <a href="{{.To}}">{{.To}}</a>.</p>
+</body>
+</html>
+`))
+
+// Redirect sends requests for From, a URL path such as "/old/page/", to To,
+// a URL path within the site or an absolute URL elsewhere.
+type Redirect struct {
+	From string `json:"from" yaml:"from"`
+	To   string `json:"to" yaml:"to"`
+	// Status is the HTTP status hosts should answer with: 301, 302, 303,
+	// 307 or 308. It defaults to 301. Stub pages cannot carry a status and
+	// always redirect client-side.
+	Status int `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+// RedirectOptions configures WriteRedirects.
+type RedirectOptions struct {
+	// Policy maps redirect URLs to output paths. It defaults to pretty URLs
+	// at the site root.
+	Policy *URLPolicy
+	// NetlifyFile, when set, is the output path of a Netlify-style
+	// redirects file, usually "_redirects". Its rules are forced so they
+	// take precedence over the stub pages.
+	NetlifyFile string
+	// NginxFile, when set, is the output path of an nginx snippet with one
+	// map per status code, to be included in the http block.
+	NginxFile string
+}
+
+// LoadRedirects reads a list of redirects from a .json, .yaml or .yml file.
+func LoadRedirects(path string) ([]Redirect, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("foundry: load redirects: %w", err)
+	}
+	var rules []Redirect
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := unmarshalYAML(data, &rules); err != nil {
+			return nil, fmt.Errorf("foundry: parse redirects yaml: %w", err)
+		}
+	default:
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("foundry: parse redirects json: %w", err)
+		}
+	}
+	return rules, nil
+}
+
+// redirectRule is a validated Redirect with the output paths it involves.
+type redirectRule struct {
+	Redirect
+	// stub is the output path of the stub page for From.
+	stub string
+	// target is the output path To resolves to, or "" for external URLs.
+	target string
+	// path is the unescaped path of From, as nginx matches it.
+	path string
+}
+
+// WriteRedirects writes a meta-refresh stub page with a canonical link for
+// every rule through out, so unchanged stubs are left alone, and the host
+// files requested in opts. Call it after all pages have been written: every
+// internal target must have been written in this build, no source may replace
+// a page, and no target may itself be redirected, which rules out chains and
+// loops. All problems are reported together before anything is written.
+func WriteRedirects(out *Output, rules []Redirect, opts RedirectOptions) error {
+	if out == nil {
+		return errors.New("foundry: redirects output is nil")
+	}
+	policy := opts.Policy
+	if policy == nil {
+		var err error
+		if policy, err = NewURLPolicy(URLOptions{}); err != nil {
+			return err
+		}
+	}
+	resolved, err := resolveRedirects(out, policy, rules)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range resolved {
+		var buf bytes.Buffer
+		data := struct{ To, Canonical string }{To: r.To, Canonical: policy.absolute(r.To)}
+		if err := redirectStub.Execute(&buf, data); err != nil {
+			return fmt.Errorf("foundry: render redirect %s: %w", r.From, err)
+		}
+		if err := out.WriteIfChanged(r.stub, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	if opts.NetlifyFile != "" {
+		if err := out.WriteIfChanged(opts.NetlifyFile, netlifyRedirects(resolved)); err != nil {
+			return err
+		}
+	}
+	if opts.NginxFile != "" {
+		if err := out.WriteIfChanged(opts.NginxFile, nginxRedirects(resolved)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resolveRedirects(out *Output, policy *URLPolicy, rules []Redirect) ([]redirectRule, error) {
+	var errs []error
+	resolved := make([]redirectRule, 0, len(rules))
+	stubs := make(map[string]string)
+	for _, r := range rules {
+		if r.Status == 0 {
+			r.Status = defaultRedirectStatus
+		}
+		rule, err := resolveRedirect(policy, r)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if prev, ok := stubs[rule.stub]; ok {
+			errs = append(errs, fmt.Errorf("foundry: redirects from %s and %s both write %s", prev, r.From, rule.stub))
+			continue
+		}
+		if out.isTouched(rule.stub) {
+			errs = append(errs, fmt.Errorf("foundry: redirect from %s would replace page %s", r.From, rule.stub))
+			continue
+		}
+		stubs[rule.stub] = r.From
+		resolved = append(resolved, rule)
+	}
+
+	bySource := make(map[string]redirectRule, len(resolved))
+	for _, r := range resolved {
+		bySource[r.stub] = r
+	}
+	for _, r := range resolved {
+		if r.target == "" {
+			continue
+		}
+		if _, ok := bySource[r.target]; ok {
+			errs = append(errs, fmt.Errorf("foundry: redirect chain %s", redirectChain(bySource, r)))
+			continue
+		}
+		if !out.isTouched(r.target) {
+			errs = append(errs, fmt.Errorf("foundry: redirect from %s points to %s, which this build did not write", r.From, r.To))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return resolved, nil
+}
+
+func resolveRedirect(policy *URLPolicy, r Redirect) (redirectRule, error) {
+	switch r.Status {
+	case 301, 302, 303, 307, 308:
+	default:
+		return redirectRule{}, fmt.Errorf("foundry: redirect from %s has unsupported status %d", r.From, r.Status)
+	}
+	from, err := url.Parse(r.From)
+	if err != nil || !strings.HasPrefix(r.From, "/") || strings.HasPrefix(r.From, "//") || from.RawQuery != "" || from.Fragment != "" {
+		return redirectRule{}, fmt.Errorf("foundry: redirect source %q must be a URL path", r.From)
+	}
+	stub, err := policy.OutputForURL(from.Path)
+	if err != nil {
+		return redirectRule{}, err
+	}
+	rule := redirectRule{Redirect: r, stub: stub, path: from.Path}
+
+	to, err := url.Parse(r.To)
+	if err != nil || r.To == "" {
+		return redirectRule{}, fmt.Errorf("foundry: redirect from %s has invalid target %q", r.From, r.To)
+	}
+	if to.Host != "" && !policy.internal(to) {
+		if to.Scheme != "" && to.Scheme != "http" && to.Scheme != "https" {
+			return redirectRule{}, fmt.Errorf("foundry: redirect from %s has invalid target %q", r.From, r.To)
+		}
+		return rule, nil
+	}
+	if to.Host == "" && (to.Scheme != "" || !strings.HasPrefix(to.Path, "/")) {
+		return redirectRule{}, fmt.Errorf("foundry: redirect target %q must be a URL path or absolute URL", r.To)
+	}
+	if rule.target, err = policy.OutputForURL(to.Path); err != nil {
+		return redirectRule{}, err
+	}
+	return rule, nil
+}
+
+// redirectChain describes the hops starting at r, stopping at the first path
+// that is not redirected or that repeats.
+func redirectChain(bySource map[string]redirectRule, r redirectRule) string {
+	hops := []string{r.From}
+	seen := map[string]bool{r.stub: true}
+	for {
+		hops = append(hops, r.To)
+		next, ok := bySource[r.target]
+		if r.target == "" || !ok {
+			return strings.Join(hops, " -> ")
+		}
+		if seen[next.stub] {
+			return strings.Join(hops, " -> ") + " (loop)"
+		}
+		seen[next.stub] = true
+		r = next
+	}
+}
+
+// netlifyRedirects renders one forced rule per redirect. Netlify serves an
+// existing file in preference to an unforced rule, and every rule has a stub
+// page at its source, so without the "!" the stub would always win.
+func netlifyRedirects(rules []redirectRule) []byte {
+	var buf bytes.Buffer
+	for _, r := range rules {
+		fmt.Fprintf(&buf, "%s %s %d!\n", r.From, r.To, r.Status)
+	}
+	return buf.Bytes()
+}
+
+// nginxRedirects renders one map per status code from request URI to target,
+// with the server block lines that act on them.
+func nginxRedirects(rules []redirectRule) []byte {
+	byStatus := make(map[int][]redirectRule)
+	for _, r := range rules {
+		byStatus[r.Status] = append(byStatus[r.Status], r)
+	}
+	statuses := make([]int, 0, len(byStatus))
+	for status := range byStatus {
+		statuses = append(statuses, status)
+	}
+	slices.Sort(statuses)
+
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by foundry. Include in the http block and add to the server block:\n")
+	for _, status := range statuses {
+		fmt.Fprintf(&buf, "#   if ($foundry_redirect_%d) { return %d $foundry_redirect_%d; }\n", status, status, status)
+	}
+	for _, status := range statuses {
+		fmt.Fprintf(&buf, "\nmap $uri $foundry_redirect_%d {\n", status)
+		for _, r := range byStatus[status] {
+			fmt.Fprintf(&buf, "    %s %s;\n", nginxQuote(r.path), nginxQuote(r.To))
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.Bytes()
+}
+
+func nginxQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,157 @@
+package foundry
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteRedirects(t *testing.T) {
+	fsys := NewMemFS()
+	out, err := NewOutputFS(fsys, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	writeOutput(t, out, "guide/index.html", "guide")
+	writeOutput(t, out, "index.html", "home")
+	policy, err := NewURLPolicy(URLOptions{BaseURL: "https://example.com/docs/"})
+	if err != nil {
+		t.Fatalf("NewURLPolicy: %v", err)
+	}
+
+	rules := []Redirect{
+		{From: "/docs/old-guide/", To: "/docs/guide/#install"},
+		{From: "/docs/start.html", To: "https://example.com/docs/", Status: 302},
+		{From: "/docs/chat/", To: "https://chat.example.org/"},
+	}
+	opts := RedirectOptions{Policy: policy, NetlifyFile: "_redirects", NginxFile: "redirects.conf"}
+	if err := WriteRedirects(out, rules, opts); err != nil {
+		t.Fatalf("WriteRedirects: %v", err)
+	}
+
+	stub := readMem(t, fsys, "old-guide/index.html")
+	for _, want := range []string{
+		`<link rel="canonical" href="https://example.com/docs/guide/#install">`,
+		`<meta http-equiv="refresh" content="0; url=/docs/guide/#install">`,
+		`<meta name="robots" content="noindex">`,
+	} {
+		if !strings.Contains(stub, want) {
+			t.Errorf("stub missing %s:\n%s", want, stub)
+		}
+	}
+	if got := readMem(t, fsys, "start.html"); !strings.Contains(got, `url=https://example.com/docs/`) {
+		t.Errorf("file stub = %s", got)
+	}
+
+	wantNetlify := "/docs/old-guide/ /docs/guide/#install 301!\n/docs/start.html https://example.com/docs/ 302!\n/docs/chat/ https://chat.example.org/ 301!\n"
+	if got := readMem(t, fsys, "_redirects"); got != wantNetlify {
+		t.Errorf("_redirects = %q want %q", got, wantNetlify)
+	}
+	nginx := readMem(t, fsys, "redirects.conf")
+	for _, want := range []string{
+		"map $uri $foundry_redirect_301 {\n    \"/docs/old-guide/\" \"/docs/guide/#install\";\n    \"/docs/chat/\" \"https://chat.example.org/\";\n}",
+		"map $uri $foundry_redirect_302 {\n    \"/docs/start.html\" \"https://example.com/docs/\";\n}",
+		"if ($foundry_redirect_302) { return 302 $foundry_redirect_302; }",
+	} {
+		if !strings.Contains(nginx, want) {
+			t.Errorf("nginx snippet missing %q:\n%s", want, nginx)
+		}
+	}
+
+	// A rebuild with the same rules leaves every file unchanged.
+	out2, err := NewOutputFS(fsys, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	writeOutput(t, out2, "guide/index.html", "guide")
+	writeOutput(t, out2, "index.html", "home")
+	if err := WriteRedirects(out2, rules, opts); err != nil {
+		t.Fatalf("WriteRedirects rebuild: %v", err)
+	}
+	if stats := out2.ChangeReport().Totals; stats.Changed != 0 {
+		t.Fatalf("expected no changes on rebuild, got %+v", stats)
+	}
+}
+
+func TestWriteRedirectsValidates(t *testing.T) {
+	out, err := NewOutputFS(NewMemFS(), OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewOutputFS: %v", err)
+	}
+	writeOutput(t, out, "new/index.html", "new")
+	writeOutput(t, out, "page/index.html", "page")
+
+	rules := []Redirect{
+		{From: "/a/", To: "/b/"},
+		{From: "/b", To: "/new/"},
+		{From: "/loop/", To: "/loop/"},
+		{From: "/missing/", To: "/nowhere/"},
+		{From: "/page/", To: "/new/"},
+		{From: "/dup/", To: "/new/"},
+		{From: "/dup", To: "/new/"},
+		{From: "relative", To: "/new/"},
+		{From: "/mail/", To: "mailto:a@example.com"},
+		{From: "/status/", To: "/new/", Status: 200},
+	}
+	err = WriteRedirects(out, rules, RedirectOptions{})
+	if err == nil {
+		t.Fatalf("expected validation errors")
+	}
+	for _, want := range []string{
+		"redirect chain /a/ -> /b/ -> /new/",
+		"redirect chain /loop/ -> /loop/ (loop)",
+		"points to /nowhere/, which this build did not write",
+		"redirect from /page/ would replace page page/index.html",
+		"redirects from /dup/ and /dup both write dup/index.html",
+		`redirect source "relative" must be a URL path`,
+		`redirect target "mailto:a@example.com" must be a URL path or absolute URL`,
+		"unsupported status 200",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q:\n%v", want, err)
+		}
+	}
+	if touched := out.Touched(); len(touched) != 2 {
+		t.Fatalf("expected nothing written on failure, got %v", touched)
+	}
+}
+
+func TestLoadRedirects(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "redirects.yaml")
+	if err := os.WriteFile(yamlPath, []byte("- from: /old/\n  to: /new/\n- from: /tmp/\n  to: /new/\n  status: 302\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	jsonPath := filepath.Join(dir, "redirects.json")
+	if err := os.WriteFile(jsonPath, []byte(`[{"from":"/old/","to":"/new/"},{"from":"/tmp/","to":"/new/","status":302}]`), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	want := []Redirect{{From: "/old/", To: "/new/"}, {From: "/tmp/", To: "/new/", Status: 302}}
+	for _, p := range []string{yamlPath, jsonPath} {
+		got, err := LoadRedirects(p)
+		if err != nil {
+			t.Fatalf("LoadRedirects(%s): %v", p, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("LoadRedirects(%s) = %+v want %+v", p, got, want)
+		}
+	}
+}
+
+func writeOutput(t *testing.T, out *Output, rel string, content string) {
+	t.Helper()
+	if err := out.WriteIfChanged(rel, []byte(content)); err != nil {
+		t.Fatalf("write %s: %v", rel, err)
+	}
+}
+
+func readMem(t *testing.T, fsys *MemFS, name string) string {
+	t.Helper()
+	data, err := fsys.ReadFile(name)
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return string(data)
+}
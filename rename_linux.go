@@ -0,0 +1,24 @@
+//go:build linux
+
+package foundry
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// exchangePaths atomically swaps the directory entries a and b with
+// renameat2(RENAME_EXCHANGE). It returns an error wrapping
+// errors.ErrUnsupported when the kernel or filesystem cannot exchange.
+func exchangePaths(a string, b string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, unix.ENOSYS), errors.Is(err, unix.EINVAL), errors.Is(err, unix.EOPNOTSUPP):
+		return fmt.Errorf("foundry: exchange %s and %s: %w: %w", a, b, errors.ErrUnsupported, err)
+	}
+	return fmt.Errorf("foundry: exchange %s and %s: %w", a, b, err)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package foundry
+
+import (
+	"errors"
+	"fmt"
+)
+
+// exchangePaths reports errors.ErrUnsupported: only Linux offers an atomic
+// exchange of two directory entries.
+func exchangePaths(a string, b string) error {
+	return fmt.Errorf("foundry: exchange %s and %s: %w", a, b, errors.ErrUnsupported)
+}
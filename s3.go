@@ -0,0 +1,274 @@
+package foundry
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultS3Workers = 8
+	// emptyPayloadHash is the hex SHA-256 of an empty body.
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	amzDateFormat    = "20060102T150405Z"
+)
+
+// S3Options configures an S3Deployer.
+type S3Options struct {
+	// Endpoint is the service URL, such as
+	// "https://s3.eu-west-1.amazonaws.com" or a MinIO or R2 address.
+	// Objects are addressed path-style: Endpoint/Bucket/Prefix+path.
+	Endpoint string
+	Bucket   string
+	// Region is used for request signing. It defaults to "us-east-1".
+	Region string
+	// Prefix is prepended to every object key, for example "site/".
+	Prefix string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is sent for temporary credentials.
+	SessionToken string
+
+	// CacheControl, when set, returns the Cache-Control header for the
+	// slash-separated output path of each uploaded object. An empty result
+	// omits the header.
+	CacheControl func(path string) string
+	// Workers bounds concurrent requests. It defaults to 8.
+	Workers int
+	// Client sends requests. It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// S3Deployer uploads changesets to an S3-compatible object store with
+// AWS Signature Version 4 authenticated PUT and DELETE requests. Upload bodies
+// are streamed and signed with the hashes recorded in the changeset, so
+// content is not hashed again.
+type S3Deployer struct {
+	opts     S3Options
+	endpoint *url.URL
+	now      func() time.Time
+}
+
+// NewS3Deployer validates opts and returns a Deployer for the bucket.
+func NewS3Deployer(opts S3Options) (*S3Deployer, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, errors.New("foundry: s3 endpoint and bucket are required")
+	}
+	if opts.AccessKeyID == "" || opts.SecretAccessKey == "" {
+		return nil, errors.New("foundry: s3 credentials are required")
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("foundry: invalid s3 endpoint %q", opts.Endpoint)
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Workers < 0 {
+		return nil, fmt.Errorf("foundry: s3 workers must not be negative (got %d)", opts.Workers)
+	}
+	if opts.Workers == 0 {
+		opts.Workers = defaultS3Workers
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &S3Deployer{opts: opts, endpoint: endpoint, now: time.Now}, nil
+}
+
+// Deploy implements Deployer. Requests within a phase run concurrently; the
+// first phase with failures stops the deploy and reports all of them.
+func (d *S3Deployer) Deploy(ctx context.Context, src fs.FS, cs *Changeset) error {
+	if src == nil || cs == nil {
+		return errors.New("foundry: deploy source and changeset must be non-nil")
+	}
+	for _, phase := range deployPhases(cs) {
+		var mu sync.Mutex
+		var errs []error
+		err := ForEachParallel(phase, d.opts.Workers, func(e ChangesetEntry) {
+			var err error
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			} else if e.Action == ActionRemove {
+				err = d.delete(ctx, e)
+			} else {
+				err = d.put(ctx, src, e)
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("foundry: deploy %s: %w", e.Path, err))
+				mu.Unlock()
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+	}
+	return nil
+}
+
+func (d *S3Deployer) put(ctx context.Context, src fs.FS, e ChangesetEntry) error {
+	f, err := src.Open(e.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, d.objectURL(e.Path), io.NopCloser(f))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = e.Size
+	if e.Size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", e.ContentType)
+	if d.opts.CacheControl != nil {
+		if cc := d.opts.CacheControl(e.Path); cc != "" {
+			req.Header.Set("Cache-Control", cc)
+		}
+	}
+	return d.send(req, e.Hash)
+}
+
+func (d *S3Deployer) delete(ctx context.Context, e ChangesetEntry) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, d.objectURL(e.Path), nil)
+	if err != nil {
+		return err
+	}
+	return d.send(req, emptyPayloadHash)
+}
+
+func (d *S3Deployer) send(req *http.Request, payloadHash string) error {
+	d.sign(req, payloadHash, d.now())
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 || (req.Method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// objectURL returns the path-style URL of the object for an output path.
+func (d *S3Deployer) objectURL(name string) string {
+	u := *d.endpoint
+	u.Path = path.Join("/", u.Path, d.opts.Bucket, d.opts.Prefix+name)
+	u.RawPath = s3EscapePath(u.Path)
+	return u.String()
+}
+
+// sign adds AWS Signature Version 4 headers to req, covering the host and
+// every header already set on it.
+func (d *S3Deployer) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	scope := amzDate[:8] + "/" + d.opts.Region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if d.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", d.opts.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashBytes([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+d.opts.SecretAccessKey), amzDate[:8])
+	key = hmacSHA256(key, d.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.opts.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// s3EscapePath percent-encodes p as SigV4 requires for S3 object paths.
+func s3EscapePath(p string) string {
+	return uriEncode(p, false)
+}
+
+func canonicalQuery(values url.Values) string {
+	var pairs []string
+	for name, vals := range values {
+		for _, v := range vals {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes every byte of s except unreserved characters and,
+// unless encodeSlash is set, "/".
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isUnreserved(c) || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '_' || c == '.' || c == '~'
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
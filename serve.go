@@ -0,0 +1,361 @@
+package foundry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultServeAddr = "localhost:8080"
+	defaultNotFound  = "404.html"
+	// liveReloadPath is the Server-Sent Events endpoint pages subscribe to.
+	liveReloadPath = "/_foundry/livereload"
+)
+
+// liveReloadScript reloads the page whenever the server reports a finished
+// build. EventSource reconnects on its own when the server restarts.
+const liveReloadScript = `<script>(function(){var es=new EventSource("` + liveReloadPath + `");es.addEventListener("reload",function(){location.reload()});})();</script>`
+
+var buildErrorPage = template.Must(template.New("build-error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Build failed</title>
+<style>
+body{margin:0;font-family:system-ui,sans-serif;background:#1e1e1e;color:#eee}
+main{max-width:60rem;margin:3rem auto;padding:0 1.5rem}
+h1{color:#ff6b6b;font-size:1.4rem}
+pre{white-space:pre-wrap;background:#2b2b2b;padding:1rem;border-left:4px solid #ff6b6b;overflow:auto}
+</style>
+</head>
+<body>
+<main>
+<h1>Build failed</h1>
+<pre>{{.Error}}</pre>
+<p>This page reloads when the next build succeeds.</p>
+</main>
+</body>
+</html>
+`))
+
+// ServeOptions configures Serve and NewDevServer.
+type ServeOptions struct {
+	// Addr is the address Serve listens on. It defaults to "localhost:8080";
+	// use ":0" for any free port.
+	Addr string
+	// NotFound is the page, relative to the served directory, returned with
+	// status 404 for missing paths. It defaults to "404.html"; when the page
+	// does not exist a plain-text response is sent.
+	NotFound string
+	// DisableLiveReload stops the reload script being injected into HTML
+	// responses.
+	DisableLiveReload bool
+	// Watch, when set, runs Watch alongside the server and reloads browsers
+	// after every build. Failed builds replace HTML pages with an error
+	// overlay until a build succeeds. The served directory is added to
+	// Watch.Ignore.
+	Watch *WatchOptions
+}
+
+// DevServer is the http.Handler behind Serve, exported for callers that run
+// their own http.Server. Call Reload after each build to refresh connected
+// browsers.
+type DevServer struct {
+	dir  string
+	opts ServeOptions
+
+	mu       sync.Mutex
+	buildErr error
+	clients  map[chan struct{}]struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewDevServer returns a handler serving the files in dir. dir is opened per
+// request, so it may be created or replaced by later builds.
+func NewDevServer(dir string, opts ServeOptions) *DevServer {
+	if opts.NotFound == "" {
+		opts.NotFound = defaultNotFound
+	}
+	return &DevServer{
+		dir:     dir,
+		opts:    opts,
+		clients: make(map[chan struct{}]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Serve serves dir over HTTP until ctx is done, optionally rebuilding with
+// Watch and live-reloading browsers. It returns nil after a clean shutdown.
+func Serve(ctx context.Context, dir string, opts ServeOptions) error {
+	if dir == "" {
+		return errors.New("foundry: serve directory is empty")
+	}
+	if opts.Addr == "" {
+		opts.Addr = defaultServeAddr
+	}
+	s := NewDevServer(dir, opts)
+	ln, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return fmt.Errorf("foundry: listen: %w", err)
+	}
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(ln) }()
+	logf("serving %s at http://%s/", dir, ln.Addr())
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	watchErr := make(chan error, 1)
+	if opts.Watch != nil {
+		wopts := s.watchOptions(*opts.Watch)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchErr <- Watch(ctx, wopts)
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+	case err = <-watchErr:
+	case err = <-serveErr:
+		err = fmt.Errorf("foundry: serve: %w", err)
+	}
+	cancel()
+	wg.Wait()
+	s.Close()
+	shutdownCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	if shutdownErr := srv.Shutdown(shutdownCtx); err == nil && shutdownErr != nil {
+		err = fmt.Errorf("foundry: shut down server: %w", shutdownErr)
+	}
+	return err
+}
+
+// watchOptions wraps the build of opts so each result reaches the browsers.
+func (s *DevServer) watchOptions(opts WatchOptions) WatchOptions {
+	opts.Ignore = append(slices.Clone(opts.Ignore), s.dir)
+	if opts.Build == nil && len(opts.Command) > 0 {
+		opts.Build = commandBuild(opts.Command)
+	}
+	if build := opts.Build; build != nil {
+		opts.Build = func(ctx context.Context, changed []string) error {
+			err := build(ctx, changed)
+			if ctx.Err() == nil {
+				s.Reload(err)
+			}
+			return err
+		}
+	}
+	return opts
+}
+
+// Reload records the outcome of a build and tells connected browsers to
+// reload. While buildErr is non-nil, HTML requests get an error overlay
+// instead of the stale pages.
+func (s *DevServer) Reload(buildErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buildErr = buildErr
+	for ch := range s.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close ends open live-reload streams so the server can shut down.
+func (s *DevServer) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// ServeHTTP implements http.Handler.
+func (s *DevServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == liveReloadPath {
+		s.events(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fsys := os.DirFS(s.dir)
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	info, err := fs.Stat(fsys, name)
+	if err == nil && info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+		name = path.Join(name, "index.html")
+		info, err = fs.Stat(fsys, name)
+	}
+	found := err == nil && info.Mode().IsRegular()
+
+	if buildErr := s.lastError(); buildErr != nil && (!found || isHTML(name)) {
+		s.serveBuildError(w, r, buildErr)
+		return
+	}
+	if !found {
+		s.notFound(w, r, fsys)
+		return
+	}
+	s.serveFile(w, r, fsys, name, http.StatusOK)
+}
+
+func (s *DevServer) lastError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buildErr
+}
+
+func (s *DevServer) notFound(w http.ResponseWriter, r *http.Request, fsys fs.FS) {
+	if info, err := fs.Stat(fsys, s.opts.NotFound); err == nil && info.Mode().IsRegular() {
+		s.serveFile(w, r, fsys, s.opts.NotFound, http.StatusNotFound)
+		return
+	}
+	http.Error(w, "404 page not found", http.StatusNotFound)
+}
+
+// serveFile serves name from fsys. HTML is read whole so the live-reload
+// script can be injected; other files are streamed with http.ServeContent,
+// which answers Range and conditional requests as media playback needs.
+func (s *DevServer) serveFile(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string, status int) {
+	if !isHTML(name) && status == http.StatusOK {
+		f, err := fsys.Open(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		content, ok := f.(io.ReadSeeker)
+		if !ok {
+			data, err := io.ReadAll(f)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			content = bytes.NewReader(data)
+		}
+		w.Header().Set("Content-Type", ContentType(name))
+		w.Header().Set("Cache-Control", "no-store")
+		http.ServeContent(w, r, name, info.ModTime(), content)
+		return
+	}
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if isHTML(name) {
+		content = s.inject(content)
+	}
+	w.Header().Set("Content-Type", ContentType(name))
+	s.writeBody(w, r, content, status)
+}
+
+func (s *DevServer) serveBuildError(w http.ResponseWriter, r *http.Request, buildErr error) {
+	var buf bytes.Buffer
+	if err := buildErrorPage.Execute(&buf, buildErr); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	s.writeBody(w, r, s.inject(buf.Bytes()), http.StatusInternalServerError)
+}
+
+func (s *DevServer) writeBody(w http.ResponseWriter, r *http.Request, content []byte, status int) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(content)
+	}
+}
+
+// inject inserts the live-reload script before the closing body tag, or
+// appends it when there is none.
+func (s *DevServer) inject(content []byte) []byte {
+	if s.opts.DisableLiveReload {
+		return content
+	}
+	i := bytes.LastIndex(bytes.ToLower(content), []byte("</body>"))
+	if i < 0 {
+		i = len(content)
+	}
+	out := make([]byte, 0, len(content)+len(liveReloadScript))
+	out = append(out, content[:i]...)
+	out = append(out, liveReloadScript...)
+	return append(out, content[i:]...)
+}
+
+// events streams a "reload" event to the browser after every build.
+func (s *DevServer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.clients[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 1000\n\n")
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-ch:
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func isHTML(name string) bool {
+	return strings.HasPrefix(ContentType(name), "text/html")
+}
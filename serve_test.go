@@ -0,0 +1,183 @@
+package foundry
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDevServerServesFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"index.html":      "<html><body><h1>Home</h1></body></html>",
+		"blog/index.html": "<p>Blog</p>",
+		"css/site.css":    "body{}",
+		"404.html":        "<body>Missing</body>",
+	})
+	s := NewDevServer(dir, ServeOptions{})
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/", http.StatusOK, "text/html; charset=utf-8", "<html><body><h1>Home</h1>" + liveReloadScript + "</body></html>"},
+		{"/blog/", http.StatusOK, "text/html; charset=utf-8", "<p>Blog</p>" + liveReloadScript},
+		{"/css/site.css", http.StatusOK, "text/css; charset=utf-8", "body{}"},
+		{"/nope", http.StatusNotFound, "text/html; charset=utf-8", "<body>Missing" + liveReloadScript + "</body>"},
+		{"/../index.html", http.StatusOK, "text/html; charset=utf-8", "<html><body><h1>Home</h1>" + liveReloadScript + "</body></html>"},
+	}
+	for _, tt := range tests {
+		status, contentType, body := httpGet(t, client, srv.URL+tt.path)
+		if status != tt.status || contentType != tt.contentType || body != tt.body {
+			t.Errorf("GET %s = %d %q %q, want %d %q %q", tt.path, status, contentType, body, tt.status, tt.contentType, tt.body)
+		}
+	}
+
+	resp, err := client.Get(srv.URL + "/blog?page=2")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/blog/?page=2" {
+		t.Fatalf("directory redirect = %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestDevServerRangeRequests(t *testing.T) {
+	dir := t.TempDir()
+	video := strings.Repeat("0123456789", 100)
+	writeTree(t, dir, map[string]string{"media/clip.mp4": video})
+	s := NewDevServer(dir, ServeOptions{})
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/media/clip.mp4", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Range", "bytes=10-19")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "0123456789" {
+		t.Fatalf("range = %d %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes 10-19/1000" {
+		t.Fatalf("Content-Range = %q", got)
+	}
+	if got := resp.Header.Get("Accept-Ranges"); got != "bytes" {
+		t.Fatalf("Accept-Ranges = %q", got)
+	}
+
+	req.Header.Del("Range")
+	req.Header.Set("If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("conditional GET = %d, want 304", resp.StatusCode)
+	}
+}
+
+func TestDevServerBuildErrorOverlay(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"index.html": "<p>stale</p>", "app.js": "js"})
+	s := NewDevServer(dir, ServeOptions{DisableLiveReload: true})
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	s.Reload(errors.New("template <main> failed"))
+	status, _, body := httpGet(t, http.DefaultClient, srv.URL+"/")
+	if status != http.StatusInternalServerError || strings.Contains(body, "stale") || !strings.Contains(body, "template &lt;main&gt; failed") {
+		t.Fatalf("overlay = %d %q", status, body)
+	}
+	if status, _, body := httpGet(t, http.DefaultClient, srv.URL+"/app.js"); status != http.StatusOK || body != "js" {
+		t.Fatalf("asset during failed build = %d %q", status, body)
+	}
+
+	s.Reload(nil)
+	if status, _, body := httpGet(t, http.DefaultClient, srv.URL+"/"); status != http.StatusOK || body != "<p>stale</p>" {
+		t.Fatalf("after fix = %d %q", status, body)
+	}
+}
+
+func TestDevServerLiveReloadEvents(t *testing.T) {
+	s := NewDevServer(t.TempDir(), ServeOptions{})
+	defer s.Close()
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + liveReloadPath)
+	if err != nil {
+		t.Fatalf("GET events: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		s.Reload(nil)
+		select {
+		case line := <-lines:
+			if line == "event: reload" {
+				return
+			}
+		case <-time.After(20 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("no reload event received")
+		}
+	}
+}
+
+func TestServeReturnsWatchErrors(t *testing.T) {
+	err := Serve(context.Background(), t.TempDir(), ServeOptions{Addr: "127.0.0.1:0", Watch: &WatchOptions{}})
+	if err == nil || !strings.Contains(err.Error(), "watch needs") {
+		t.Fatalf("expected watch validation error, got %v", err)
+	}
+}
+
+func httpGet(t *testing.T, client *http.Client, url string) (int, string, string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read %s: %v", url, err)
+	}
+	return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+}
@@ -0,0 +1,191 @@
+package foundry
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// StagedOutput is an Output that writes into a staging directory beside the
+// real output root and only replaces the root when the build commits. Readers
+// of the root, such as a dev server, never see a half-finished build, and a
+// failed build leaves the previous output in place.
+//
+// The staging directory starts as a copy of the current root made of hard
+// links, so unchanged files cost nothing to carry over and the Manifest stays
+// valid. Every write replaces files by rename, so writing to a staged path
+// never modifies the linked original; restoring FileMode or ModTime on an
+// unchanged file rewrites it as well rather than changing the shared file.
+type StagedOutput struct {
+	*Output
+
+	root    string
+	staging string
+	done    bool
+}
+
+// NewStagedOutput prepares a staging directory for root and returns an Output
+// writing into it. Call Commit when the build succeeds and Abort otherwise;
+// deferring Abort is safe because it does nothing after Commit. A staging
+// directory left behind by an interrupted build is discarded. DryRun is not
+// supported, since a dry run never modifies the root anyway.
+func NewStagedOutput(root string, opts OutputOptions) (*StagedOutput, error) {
+	if root == "" {
+		return nil, errors.New("foundry: output root is empty")
+	}
+	if opts.DryRun {
+		return nil, errors.New("foundry: staged output does not support dry runs")
+	}
+	root = filepath.Clean(root)
+	staging := siblingPath(root, "staging")
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, fmt.Errorf("foundry: clear staging dir: %w", err)
+	}
+	if err := seedStaging(root, staging); err != nil {
+		_ = os.RemoveAll(staging)
+		return nil, err
+	}
+	fsys := NewDiskFS(staging)
+	fsys.breakLinks = true
+	out, err := NewOutputFS(fsys, opts)
+	if err != nil {
+		_ = os.RemoveAll(staging)
+		return nil, err
+	}
+	return &StagedOutput{Output: out, root: root, staging: staging}, nil
+}
+
+// FinalRoot returns the directory that Commit replaces. Root returns the
+// staging directory.
+func (s *StagedOutput) FinalRoot() string {
+	return s.root
+}
+
+// Commit replaces the output root with the staging directory. On Linux the
+// two are exchanged with a single atomic rename, so the root is never
+// missing. Elsewhere, or on filesystems without exchange support, the
+// previous root is renamed aside first, so the root is missing for the
+// instant between two renames; if the second rename fails the previous root
+// is put back. The previous output is deleted afterwards. Prune, if wanted,
+// must be called before Commit.
+func (s *StagedOutput) Commit() error {
+	if s.done {
+		return errors.New("foundry: staged output already committed or aborted")
+	}
+	if err := s.release(); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(s.root); err == nil {
+		err := exchangePaths(s.staging, s.root)
+		if err == nil {
+			s.done = true
+			// The staging path now holds the previous output.
+			if err := os.RemoveAll(s.staging); err != nil {
+				return fmt.Errorf("foundry: remove previous output: %w", err)
+			}
+			return nil
+		}
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return fmt.Errorf("foundry: commit staged output: %w", err)
+		}
+	}
+	previous := siblingPath(s.root, "previous")
+	if err := os.RemoveAll(previous); err != nil {
+		return fmt.Errorf("foundry: clear previous output: %w", err)
+	}
+	hadRoot := true
+	if err := os.Rename(s.root, previous); errors.Is(err, fs.ErrNotExist) {
+		hadRoot = false
+	} else if err != nil {
+		return fmt.Errorf("foundry: move previous output aside: %w", err)
+	}
+	if err := os.Rename(s.staging, s.root); err != nil {
+		if hadRoot {
+			if restoreErr := os.Rename(previous, s.root); restoreErr != nil {
+				err = errors.Join(err, restoreErr)
+			}
+		}
+		return fmt.Errorf("foundry: commit staged output: %w", err)
+	}
+	s.done = true
+	if hadRoot {
+		if err := os.RemoveAll(previous); err != nil {
+			return fmt.Errorf("foundry: remove previous output: %w", err)
+		}
+	}
+	return nil
+}
+
+// Abort discards the staging directory and leaves the output root untouched.
+// It does nothing after Commit or an earlier Abort.
+func (s *StagedOutput) Abort() error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+	if err := s.release(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.staging); err != nil {
+		return fmt.Errorf("foundry: remove staging dir: %w", err)
+	}
+	return nil
+}
+
+// release closes the staging DiskFS so the directory can be renamed or removed.
+func (s *StagedOutput) release() error {
+	if d, ok := s.fsys.(*DiskFS); ok {
+		if err := d.Close(); err != nil {
+			return fmt.Errorf("foundry: close staging dir: %w", err)
+		}
+	}
+	return nil
+}
+
+// siblingPath returns a hidden directory beside root, on the same filesystem
+// so that renames between them are atomic.
+func siblingPath(root string, suffix string) string {
+	return filepath.Join(filepath.Dir(root), "."+filepath.Base(root)+"."+suffix)
+}
+
+// seedStaging recreates root inside staging using hard links for files,
+// falling back to copies where links are not possible.
+func seedStaging(root string, staging string) error {
+	if err := EnsureDir(staging); err != nil {
+		return err
+	}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == root {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(staging, rel)
+		switch {
+		case d.IsDir():
+			return EnsureDir(dst)
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		case d.Type().IsRegular():
+			_, err := linkFileIfChanged(p, dst)
+			return err
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("foundry: seed staging dir: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package foundry
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStagedOutputCommit(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "dist")
+	writeTree(t, root, map[string]string{
+		"index.html":      "old home",
+		"about.html":      "about",
+		"blog/stale.html": "stale",
+	})
+
+	stage, err := NewStagedOutput(root, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewStagedOutput: %v", err)
+	}
+	defer stage.Abort()
+
+	if err := stage.WriteIfChanged("index.html", []byte("new home")); err != nil {
+		t.Fatalf("write index: %v", err)
+	}
+	if err := stage.WriteIfChanged("about.html", []byte("about")); err != nil {
+		t.Fatalf("write about: %v", err)
+	}
+	staged, err := stage.Path("about.html")
+	if err != nil {
+		t.Fatalf("Path: %v", err)
+	}
+	assertSameFile(t, filepath.Join(root, "about.html"), staged)
+	assertContent(t, filepath.Join(root, "index.html"), "old home")
+
+	removed, err := stage.Prune()
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if want := []string{"blog/stale.html"}; !reflect.DeepEqual(removed, want) {
+		t.Fatalf("removed got %v want %v", removed, want)
+	}
+	if _, err := os.Stat(filepath.Join(root, "blog", "stale.html")); err != nil {
+		t.Fatalf("prune touched the live output: %v", err)
+	}
+
+	if err := stage.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	assertContent(t, filepath.Join(root, "index.html"), "new home")
+	if _, err := os.Stat(filepath.Join(root, "blog")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected blog/ to be gone, got %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(root))
+	if err != nil {
+		t.Fatalf("read parent: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "dist" {
+		t.Fatalf("expected only dist/ to remain, got %v", entries)
+	}
+	if err := stage.Commit(); err == nil {
+		t.Fatalf("expected second Commit to fail")
+	}
+}
+
+func TestStagedOutputAbort(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "dist")
+	writeTree(t, root, map[string]string{"index.html": "old home"})
+
+	stage, err := NewStagedOutput(root, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewStagedOutput: %v", err)
+	}
+	if err := stage.WriteIfChanged("index.html", []byte("half-built")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := stage.Abort(); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+	assertContent(t, filepath.Join(root, "index.html"), "old home")
+	if _, err := os.Stat(stage.Root()); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected staging dir to be removed, got %v", err)
+	}
+}
+
+func TestStagedOutputAbortKeepsAttrs(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "dist")
+	writeTree(t, root, map[string]string{"a.html": "same"})
+	live := filepath.Join(root, "a.html")
+	before, err := os.Stat(live)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	epoch := time.Unix(0, 0)
+	stage, err := NewStagedOutput(root, OutputOptions{FileMode: 0o600, ModTime: epoch})
+	if err != nil {
+		t.Fatalf("NewStagedOutput: %v", err)
+	}
+	if err := stage.WriteIfChanged("a.html", []byte("same")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	staged, err := stage.Path("a.html")
+	if err != nil {
+		t.Fatalf("Path: %v", err)
+	}
+	assertAttrs(t, staged, 0o600, epoch)
+	if err := stage.Abort(); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+	assertAttrs(t, live, before.Mode().Perm(), before.ModTime())
+}
+
+func TestStagedOutputFirstBuild(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "dist")
+	stage, err := NewStagedOutput(root, OutputOptions{})
+	if err != nil {
+		t.Fatalf("NewStagedOutput: %v", err)
+	}
+	if err := stage.WriteIfChanged("index.html", []byte("home")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := stage.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	assertContent(t, filepath.Join(root, "index.html"), "home")
+}
+
+func assertContent(t *testing.T, path string, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if string(data) != want {
+		t.Fatalf("%s: got %q want %q", path, data, want)
+	}
+}
+
+func assertSameFile(t *testing.T, a string, b string) {
+	t.Helper()
+	infoA, err := os.Stat(a)
+	if err != nil {
+		t.Fatalf("stat %s: %v", a, err)
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		t.Fatalf("stat %s: %v", b, err)
+	}
+	if !os.SameFile(infoA, infoB) {
+		t.Fatalf("expected %s and %s to be hard links", a, b)
+	}
+}
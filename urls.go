@@ -0,0 +1,262 @@
+package foundry
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// URLStyle selects how pages are laid out in the output directory.
+type URLStyle int
+
+const (
+	// PrettyURLs writes "about.md" as "about/index.html", linked as "/about/".
+	PrettyURLs URLStyle = iota
+	// FileURLs writes "about.md" as "about.html", linked as "/about.html".
+	FileURLs
+)
+
+// TrailingSlash selects whether directory URLs end in a slash.
+type TrailingSlash int
+
+const (
+	// TrailingSlashAdd links directories as "/about/".
+	TrailingSlashAdd TrailingSlash = iota
+	// TrailingSlashRemove links directories as "/about", for hosts that
+	// serve "about/index.html" at that URL. The site root stays "/".
+	TrailingSlashRemove
+)
+
+// defaultPageExtensions are the source extensions URLPolicy treats as pages.
+var defaultPageExtensions = []string{".md", ".markdown", ".html", ".htm", ".tmpl", ".gohtml"}
+
+// URLOptions configures NewURLPolicy.
+type URLOptions struct {
+	Style         URLStyle
+	TrailingSlash TrailingSlash
+	// Lang, when set, prefixes every output path and URL, as in
+	// "en/about/index.html" and "/en/about/".
+	Lang string
+	// BaseURL is where the site is published, such as "https://example.com"
+	// or "https://example.com/docs/". Its path prefixes every URL; AbsURL
+	// requires it to be absolute.
+	BaseURL string
+	// PageExtensions lists the source extensions mapped to HTML pages. It
+	// defaults to .md, .markdown, .html, .htm, .tmpl and .gohtml. Other
+	// sources keep their path.
+	PageExtensions []string
+}
+
+// URLPolicy is the single definition of how source files map to output paths
+// and public URLs, so the dist layout and the links pointing into it cannot
+// drift apart. Source and output paths are slash-separated and relative; a
+// source named "index" maps to the directory containing it.
+type URLPolicy struct {
+	opts URLOptions
+	base *url.URL
+}
+
+// NewURLPolicy validates opts and returns the policy.
+func NewURLPolicy(opts URLOptions) (*URLPolicy, error) {
+	if opts.Style != PrettyURLs && opts.Style != FileURLs {
+		return nil, fmt.Errorf("foundry: unknown URL style %d", opts.Style)
+	}
+	if opts.TrailingSlash != TrailingSlashAdd && opts.TrailingSlash != TrailingSlashRemove {
+		return nil, fmt.Errorf("foundry: unknown trailing slash policy %d", opts.TrailingSlash)
+	}
+	if opts.Lang != "" && (strings.ContainsAny(opts.Lang, `/\`) || !fs.ValidPath(opts.Lang) || opts.Lang == ".") {
+		return nil, fmt.Errorf("foundry: invalid language prefix %q", opts.Lang)
+	}
+	base, err := url.Parse(opts.BaseURL)
+	if err != nil || base.RawQuery != "" || base.Fragment != "" {
+		return nil, fmt.Errorf("foundry: invalid base URL %q", opts.BaseURL)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	base.RawPath = ""
+	if opts.PageExtensions == nil {
+		opts.PageExtensions = defaultPageExtensions
+	}
+	opts.PageExtensions = slices.Clone(opts.PageExtensions)
+	for i, ext := range opts.PageExtensions {
+		if !strings.HasPrefix(ext, ".") {
+			return nil, fmt.Errorf("foundry: page extension %q must start with a dot", ext)
+		}
+		opts.PageExtensions[i] = strings.ToLower(ext)
+	}
+	return &URLPolicy{opts: opts, base: base}, nil
+}
+
+// Lang returns the language prefix, or "" when there is none.
+func (p *URLPolicy) Lang() string {
+	return p.opts.Lang
+}
+
+// WithLang returns a copy of the policy for another language, for example to
+// link to a page's translations.
+func (p *URLPolicy) WithLang(lang string) (*URLPolicy, error) {
+	opts := p.opts
+	opts.Lang = lang
+	return NewURLPolicy(opts)
+}
+
+// OutputPath returns the output path, relative to the output root, that the
+// source file is written to.
+func (p *URLPolicy) OutputPath(source string) (string, error) {
+	route, page, err := p.route(source)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case !page:
+		return route, nil
+	case path.Base(route) == "index":
+		return path.Join(path.Dir(route), "index.html"), nil
+	case p.opts.Style == FileURLs:
+		return route + ".html", nil
+	default:
+		return route + "/index.html", nil
+	}
+}
+
+// URL returns the public URL path of the source file, including the base
+// URL's path.
+func (p *URLPolicy) URL(source string) (string, error) {
+	output, err := p.OutputPath(source)
+	if err != nil {
+		return "", err
+	}
+	return p.outputURL(output), nil
+}
+
+// AbsURL returns the absolute URL of the source file. BaseURL must be
+// absolute.
+func (p *URLPolicy) AbsURL(source string) (string, error) {
+	if !p.base.IsAbs() {
+		return "", fmt.Errorf("foundry: absolute URLs need an absolute base URL (got %q)", p.opts.BaseURL)
+	}
+	u, err := p.URL(source)
+	if err != nil {
+		return "", err
+	}
+	return p.absolute(u), nil
+}
+
+// URLForOutput returns the public URL of a file already in the output
+// directory, such as "en/about/index.html". The language prefix is not added
+// because output paths already contain it.
+func (p *URLPolicy) URLForOutput(output string) (string, error) {
+	clean, err := cleanRelPath(output, "output")
+	if err != nil {
+		return "", err
+	}
+	return p.outputURL(clean), nil
+}
+
+// OutputForURL returns the output path serving a URL or URL path produced by
+// this policy. Paths without an extension resolve to directory index pages.
+func (p *URLPolicy) OutputForURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("foundry: parse URL %q: %w", rawURL, err)
+	}
+	if u.IsAbs() && (u.Scheme != p.base.Scheme || u.Host != p.base.Host) {
+		return "", fmt.Errorf("foundry: URL %q is outside base URL %q", rawURL, p.opts.BaseURL)
+	}
+	rest, ok := strings.CutPrefix(u.Path, p.base.Path)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return "", fmt.Errorf("foundry: URL %q is outside base URL %q", rawURL, p.opts.BaseURL)
+	}
+	name := strings.TrimPrefix(rest, "/")
+	switch {
+	case name == "" || strings.HasSuffix(name, "/"):
+		name += "index.html"
+	case path.Ext(name) != "":
+	default:
+		name += "/index.html"
+	}
+	return cleanRelPath(name, "URL")
+}
+
+// TemplateFuncs exposes the policy to templates:
+//
+//	{{ pageURL "blog/post.md" }}      -> "/en/blog/post/"
+//	{{ absURL "blog/post.md" }}       -> "https://example.com/en/blog/post/"
+//	{{ langURL "es" "blog/post.md" }} -> "/es/blog/post/"
+func (p *URLPolicy) TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"pageURL": p.URL,
+		"absURL":  p.AbsURL,
+		"langURL": func(lang string, source string) (string, error) {
+			other, err := p.WithLang(lang)
+			if err != nil {
+				return "", err
+			}
+			return other.URL(source)
+		},
+	}
+}
+
+// absolute prefixes u, a URL path, with the scheme and host of BaseURL when
+// it is absolute and returns other URLs unchanged.
+func (p *URLPolicy) absolute(u string) string {
+	if !p.base.IsAbs() || !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") {
+		return u
+	}
+	return p.base.Scheme + "://" + p.base.Host + u
+}
+
+// internal reports whether u, a URL with a host, points into the site.
+func (p *URLPolicy) internal(u *url.URL) bool {
+	return p.base.Host != "" && strings.EqualFold(u.Host, p.base.Host) && (u.Scheme == "" || u.Scheme == p.base.Scheme)
+}
+
+// route returns the language-prefixed source path with page extensions
+// removed, and whether the source is a page.
+func (p *URLPolicy) route(source string) (string, bool, error) {
+	clean, err := cleanRelPath(source, "source")
+	if err != nil {
+		return "", false, err
+	}
+	page := false
+	if ext := path.Ext(clean); ext != "" && ext != path.Base(clean) && slices.Contains(p.opts.PageExtensions, strings.ToLower(ext)) {
+		clean = strings.TrimSuffix(clean, ext)
+		page = true
+	}
+	return path.Join(p.opts.Lang, clean), page, nil
+}
+
+// outputURL maps an output path to its escaped URL path, turning index pages
+// into directory URLs.
+func (p *URLPolicy) outputURL(output string) string {
+	dir := false
+	if path.Base(output) == "index.html" {
+		output, dir = strings.TrimSuffix(strings.TrimSuffix(output, "index.html"), "/"), true
+	}
+	u := (&url.URL{Path: p.base.Path + "/" + output}).EscapedPath()
+	if dir && output != "" && p.opts.TrailingSlash == TrailingSlashAdd {
+		u += "/"
+	}
+	return u
+}
+
+// cleanRelPath normalises a relative slash path, rejecting absolute paths and
+// paths that leave their root.
+func cleanRelPath(name string, kind string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("foundry: %s path is empty", kind)
+	}
+	slashed := filepath.ToSlash(name)
+	if path.IsAbs(slashed) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("foundry: %s path %q must be relative", kind, name)
+	}
+	clean := path.Clean(slashed)
+	if clean == "." || !fs.ValidPath(clean) {
+		return "", fmt.Errorf("foundry: invalid %s path %q", kind, name)
+	}
+	return clean, nil
+}
@@ -0,0 +1,100 @@
+package foundry
+
+import (
+	"bytes"
+	"html/template"
+	"testing"
+)
+
+func TestURLPolicyMapsSources(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   URLOptions
+		source string
+		output string
+		url    string
+	}{
+		{"pretty", URLOptions{}, "about.md", "about/index.html", "/about/"},
+		{"pretty index", URLOptions{}, "index.md", "index.html", "/"},
+		{"pretty section", URLOptions{}, "blog/index.md", "blog/index.html", "/blog/"},
+		{"pretty nested", URLOptions{}, "blog/first post.md", "blog/first post/index.html", "/blog/first%20post/"},
+		{"no slash", URLOptions{TrailingSlash: TrailingSlashRemove}, "about.md", "about/index.html", "/about"},
+		{"no slash root", URLOptions{TrailingSlash: TrailingSlashRemove}, "index.md", "index.html", "/"},
+		{"file", URLOptions{Style: FileURLs}, "about.md", "about.html", "/about.html"},
+		{"file section", URLOptions{Style: FileURLs}, "blog/index.html", "blog/index.html", "/blog/"},
+		{"lang", URLOptions{Lang: "es"}, "about.md", "es/about/index.html", "/es/about/"},
+		{"lang root", URLOptions{Lang: "es"}, "index.md", "es/index.html", "/es/"},
+		{"base path", URLOptions{Lang: "en", BaseURL: "https://example.com/docs/"}, "guide.md", "en/guide/index.html", "/docs/en/guide/"},
+		{"base root", URLOptions{BaseURL: "https://example.com/docs"}, "index.md", "index.html", "/docs/"},
+		{"static file", URLOptions{Lang: "en"}, "img/logo.png", "en/img/logo.png", "/en/img/logo.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewURLPolicy(tt.opts)
+			if err != nil {
+				t.Fatalf("NewURLPolicy: %v", err)
+			}
+			output, err := p.OutputPath(tt.source)
+			if err != nil || output != tt.output {
+				t.Fatalf("OutputPath(%q) = %q, %v; want %q", tt.source, output, err, tt.output)
+			}
+			u, err := p.URL(tt.source)
+			if err != nil || u != tt.url {
+				t.Fatalf("URL(%q) = %q, %v; want %q", tt.source, u, err, tt.url)
+			}
+			if got, err := p.URLForOutput(output); err != nil || got != tt.url {
+				t.Fatalf("URLForOutput(%q) = %q, %v; want %q", output, got, err, tt.url)
+			}
+			if got, err := p.OutputForURL(u); err != nil || got != output {
+				t.Fatalf("OutputForURL(%q) = %q, %v; want %q", u, got, err, output)
+			}
+		})
+	}
+}
+
+func TestURLPolicyRejectsInvalidInput(t *testing.T) {
+	if _, err := NewURLPolicy(URLOptions{Lang: "en/us"}); err == nil {
+		t.Fatalf("expected error for nested language prefix")
+	}
+	if _, err := NewURLPolicy(URLOptions{PageExtensions: []string{"md"}}); err == nil {
+		t.Fatalf("expected error for extension without dot")
+	}
+	p, err := NewURLPolicy(URLOptions{BaseURL: "https://example.com/docs"})
+	if err != nil {
+		t.Fatalf("NewURLPolicy: %v", err)
+	}
+	for _, source := range []string{"", "/about.md", "../about.md", "."} {
+		if _, err := p.OutputPath(source); err == nil {
+			t.Errorf("expected error for source %q", source)
+		}
+	}
+	for _, u := range []string{"/other/page/", "https://evil.example/docs/", "/docs/../../etc/passwd"} {
+		if _, err := p.OutputForURL(u); err == nil {
+			t.Errorf("expected error for URL %q", u)
+		}
+	}
+	relative, err := NewURLPolicy(URLOptions{})
+	if err != nil {
+		t.Fatalf("NewURLPolicy: %v", err)
+	}
+	if _, err := relative.AbsURL("about.md"); err == nil {
+		t.Fatalf("expected error for AbsURL without absolute base")
+	}
+}
+
+func TestURLPolicyTemplateFuncs(t *testing.T) {
+	p, err := NewURLPolicy(URLOptions{Lang: "en", BaseURL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("NewURLPolicy: %v", err)
+	}
+	tmpl := template.Must(template.New("page").Funcs(p.TemplateFuncs()).Parse(
+		`<a href="{{ pageURL "blog/post.md" }}">{{ absURL "blog/post.md" }}</a><link rel="alternate" hreflang="es" href="{{ langURL "es" "blog/post.md" }}">`))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	want := `<a href="/en/blog/post/">https://example.com/en/blog/post/</a><link rel="alternate" hreflang="es" href="/es/blog/post/">`
+	if buf.String() != want {
+		t.Fatalf("got %q want %q", buf.String(), want)
+	}
+}
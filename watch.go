@@ -0,0 +1,270 @@
+package foundry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWatchInterval = 500 * time.Millisecond
+	defaultWatchDebounce = 200 * time.Millisecond
+	defaultWatchOutput   = "dist"
+)
+
+// watchIgnored lists patterns Watch always skips: foundry's temporary files,
+// the staging directories of StagedOutput and version control metadata.
+var watchIgnored = []string{".foundry-*", ".*.staging/", ".*.previous/", ".git/"}
+
+// WatchOptions configures Watch.
+//
+// Include and Exclude use the glob syntax documented on CopyDirOptions,
+// matched against paths relative to each watched directory.
+type WatchOptions struct {
+	// Dirs lists the directories to watch, such as "content", "templates",
+	// "i18n" and "static". Directories that do not exist yet are watched
+	// for creation.
+	Dirs []string
+	// Include, when non-empty, limits watching to files matching at least
+	// one pattern.
+	Include []string
+	// Exclude skips files and whole directories matching any pattern.
+	Exclude []string
+	// Ignore lists further directories that are never watched even when
+	// they sit inside a watched directory.
+	Ignore []string
+	// Output is the directory the build writes to. It is ignored like the
+	// entries of Ignore, so that a build writing inside a watched directory
+	// does not trigger itself. It defaults to "dist".
+	Output string
+
+	// Interval is the time between polls. It defaults to 500ms.
+	Interval time.Duration
+	// Debounce is how long the tree must stay quiet after a change before
+	// the build runs, so that saving many files triggers one build. It
+	// defaults to 200ms.
+	Debounce time.Duration
+
+	// Build is called with the sorted paths that changed since the previous
+	// build, or with nil for the initial build.
+	Build func(ctx context.Context, changed []string) error
+	// Command is run instead of Build when Build is nil, for example
+	// []string{"go", "run", "./build"}. Its output goes to os.Stdout and
+	// os.Stderr.
+	Command []string
+	// SkipInitialBuild suppresses the build Watch otherwise runs on start.
+	SkipInitialBuild bool
+}
+
+// fileStamp is what polling compares between scans.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+// Watch polls the configured directories until ctx is done and reruns the
+// build whenever files are created, modified or removed. Changes and build
+// failures are reported through the WithStep logger; a failing build does not
+// stop watching. Watch returns nil when ctx is done and an error only for
+// invalid options.
+func Watch(ctx context.Context, opts WatchOptions) error {
+	if len(opts.Dirs) == 0 {
+		return errors.New("foundry: watch needs at least one directory")
+	}
+	if opts.Build == nil && len(opts.Command) == 0 {
+		return errors.New("foundry: watch needs a build callback or command")
+	}
+	for _, pattern := range slices.Concat(opts.Include, opts.Exclude) {
+		if err := validateGlob(pattern); err != nil {
+			return err
+		}
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultWatchInterval
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = defaultWatchDebounce
+	}
+	if opts.Build == nil {
+		opts.Build = commandBuild(opts.Command)
+	}
+	if opts.Output == "" {
+		opts.Output = defaultWatchOutput
+	}
+	w := &watcher{opts: opts}
+	for _, dir := range append(slices.Clone(opts.Ignore), opts.Output) {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("foundry: resolve ignored dir: %w", err)
+		}
+		w.ignore = append(w.ignore, abs)
+	}
+	return w.run(ctx)
+}
+
+type watcher struct {
+	opts   WatchOptions
+	ignore []string
+}
+
+func (w *watcher) run(ctx context.Context) error {
+	snapshot := w.scan()
+	if !w.opts.SkipInitialBuild {
+		w.build(ctx, nil)
+	}
+
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	pending := make(map[string]struct{})
+	var lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			next := w.scan()
+			for _, name := range diffSnapshots(snapshot, next) {
+				pending[name] = struct{}{}
+				lastChange = now
+			}
+			snapshot = next
+			if len(pending) == 0 || now.Sub(lastChange) < w.opts.Debounce {
+				continue
+			}
+			changed := make([]string, 0, len(pending))
+			for name := range pending {
+				changed = append(changed, name)
+			}
+			slices.Sort(changed)
+			clear(pending)
+			w.build(ctx, changed)
+		}
+	}
+}
+
+// build runs the configured build and logs, rather than returns, failures.
+func (w *watcher) build(ctx context.Context, changed []string) {
+	if changed != nil {
+		logf("change detected: %s", summarizePaths(changed, 5))
+	}
+	start := time.Now()
+	err := w.opts.Build(ctx, changed)
+	duration := time.Since(start).Round(time.Millisecond)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		logf("error rebuild (%s): %v", duration, err)
+		return
+	}
+	logf("done rebuild (%s)", duration)
+}
+
+// commandBuild returns a build callback that runs command with its output
+// passed through.
+func commandBuild(command []string) func(context.Context, []string) error {
+	return func(ctx context.Context, _ []string) error {
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		return cmd.Run()
+	}
+}
+
+// scan stats every watched file. Unreadable entries are left out, so they
+// show up as removed until they can be read again.
+func (w *watcher) scan() map[string]fileStamp {
+	files := make(map[string]fileStamp)
+	for _, dir := range w.opts.Dirs {
+		_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if d != nil && d.IsDir() && p != dir {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil || rel == "." {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+			if d.IsDir() {
+				if w.ignored(p) || w.excluded(rel, true) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if w.excluded(rel, false) || !w.included(rel) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			files[p] = fileStamp{size: info.Size(), modTime: info.ModTime(), mode: info.Mode()}
+			return nil
+		})
+	}
+	return files
+}
+
+func (w *watcher) ignored(p string) bool {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(w.ignore, abs)
+}
+
+func (w *watcher) excluded(rel string, isDir bool) bool {
+	for _, pattern := range slices.Concat(watchIgnored, w.opts.Exclude) {
+		if matchGlob(pattern, rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *watcher) included(rel string) bool {
+	if len(w.opts.Include) == 0 {
+		return true
+	}
+	for _, pattern := range w.opts.Include {
+		if matchGlob(pattern, rel, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffSnapshots returns the paths created, modified or removed between two
+// scans.
+func diffSnapshots(before map[string]fileStamp, after map[string]fileStamp) []string {
+	var changed []string
+	for name, stamp := range after {
+		if prev, ok := before[name]; !ok || prev.size != stamp.size || !prev.modTime.Equal(stamp.modTime) || prev.mode != stamp.mode {
+			changed = append(changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// summarizePaths lists up to limit paths and counts the rest.
+func summarizePaths(paths []string, limit int) string {
+	if len(paths) <= limit {
+		return strings.Join(paths, ", ")
+	}
+	return fmt.Sprintf("%s (+%d more)", strings.Join(paths[:limit], ", "), len(paths)-limit)
+}
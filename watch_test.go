@@ -0,0 +1,159 @@
+package foundry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchRebuildsOnChange(t *testing.T) {
+	var logs syncBuffer
+	SetStepLogger(log.New(&logs, "", 0))
+	defer SetStepLogger(nil)
+
+	root := t.TempDir()
+	content := filepath.Join(root, "content")
+	dist := filepath.Join(content, "dist")
+	writeTree(t, content, map[string]string{"a.md": "a", "draft.tmp": "x"})
+	writeTree(t, dist, map[string]string{"index.html": "out"})
+
+	builds := make(chan []string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, WatchOptions{
+			Dirs:     []string{content},
+			Exclude:  []string{"*.tmp"},
+			Ignore:   []string{dist},
+			Interval: 10 * time.Millisecond,
+			Debounce: 30 * time.Millisecond,
+			Build: func(ctx context.Context, changed []string) error {
+				builds <- changed
+				if len(changed) > 0 {
+					return errors.New("template broke")
+				}
+				return nil
+			},
+		})
+	}()
+
+	if got := waitBuild(t, builds); got != nil {
+		t.Fatalf("expected initial build with nil changes, got %v", got)
+	}
+
+	// Ignored and excluded files do not trigger builds.
+	writeTree(t, dist, map[string]string{"index.html": "new output"})
+	writeTree(t, content, map[string]string{"draft.tmp": "changed", ".foundry-123-1": "temp"})
+	select {
+	case got := <-builds:
+		t.Fatalf("unexpected build for %v", got)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	writeTree(t, content, map[string]string{"a.md": "changed", "posts/b.md": "new"})
+	if err := os.Remove(filepath.Join(content, "draft.tmp")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	got := waitBuild(t, builds)
+	want := []string{filepath.Join(content, "a.md"), filepath.Join(content, "posts", "b.md")}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("changed got %v want %v", got, want)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if !strings.Contains(logs.String(), "change detected") || !strings.Contains(logs.String(), "template broke") {
+		t.Fatalf("expected change and error logs, got %q", logs.String())
+	}
+}
+
+func TestWatchIgnoresOutputByDefault(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"content/a.md": "a"})
+	t.Chdir(root)
+
+	builds := make(chan []string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	count := 0
+	go func() {
+		done <- Watch(ctx, WatchOptions{
+			Dirs:     []string{"."},
+			Interval: 10 * time.Millisecond,
+			Debounce: 30 * time.Millisecond,
+			Build: func(ctx context.Context, changed []string) error {
+				count++
+				builds <- changed
+				return WriteIfChanged(filepath.Join("dist", "index.html"), []byte(fmt.Sprintf("build %d", count)))
+			},
+		})
+	}()
+
+	if got := waitBuild(t, builds); got != nil {
+		t.Fatalf("expected initial build with nil changes, got %v", got)
+	}
+	writeTree(t, root, map[string]string{"content/a.md": "changed"})
+	if got := waitBuild(t, builds); !reflect.DeepEqual(got, []string{filepath.Join("content", "a.md")}) {
+		t.Fatalf("changed got %v", got)
+	}
+	// Each build rewrites dist, which must not trigger another one.
+	select {
+	case got := <-builds:
+		t.Fatalf("build output triggered a rebuild for %v", got)
+	case <-time.After(150 * time.Millisecond):
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+}
+
+func TestWatchValidatesOptions(t *testing.T) {
+	ctx := context.Background()
+	if err := Watch(ctx, WatchOptions{Build: func(context.Context, []string) error { return nil }}); err == nil {
+		t.Fatalf("expected error without directories")
+	}
+	if err := Watch(ctx, WatchOptions{Dirs: []string{"."}}); err == nil {
+		t.Fatalf("expected error without build")
+	}
+}
+
+func waitBuild(t *testing.T, builds <-chan []string) []string {
+	t.Helper()
+	select {
+	case changed := <-builds:
+		return changed
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for build")
+		return nil
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of a logger.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}